@@ -37,17 +37,17 @@ func runParse(filename string) error {
 	defer f.Close()
 
 	rls := parse.NewReplayLogStream(f)
-	tp, err := rls.Next()
+	tp, msg, err := rls.NextMessage()
 	i := 0
 
 	for err == nil {
 		fmt.Printf(
-			"%2d: Timestamp=%-27s, Client=%s, Server=%s, len(Chunk)=%d\n",
-			i, tp.Timestamp.Format(time.RFC3339Nano),
-			tp.ClientAddr, tp.ServerAddr, len(tp.Chunk),
+			"%2d: Timestamp=%-27s, Direction=%-14s, Client=%s, Server=%s, len(Chunk)=%d, Message=%T\n",
+			i, tp.Timestamp.Format(time.RFC3339Nano), tp.Direction,
+			tp.ClientAddr, tp.ServerAddr, len(tp.Chunk), msg,
 		)
 		i++
-		tp, err = rls.Next()
+		tp, msg, err = rls.NextMessage()
 	}
 
 	if err == io.EOF {
@@ -57,6 +57,50 @@ func runParse(filename string) error {
 	return err
 }
 
+func runPcapngExport(filename, out string) error {
+	binFile, err := filepath.Abs(filename)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(binFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	pcapngFile, err := filepath.Abs(out)
+	if err != nil {
+		return err
+	}
+
+	w, err := os.Create(pcapngFile)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	pw, err := parse.NewPcapNgWriter(w)
+	if err != nil {
+		return err
+	}
+
+	rls := parse.NewReplayLogStream(f)
+	tp, err := rls.Next()
+	for err == nil {
+		if err = pw.WriteTCPPacket(tp); err != nil {
+			return err
+		}
+		tp, err = rls.Next()
+	}
+
+	if err != io.EOF {
+		return err
+	}
+
+	return pw.Close()
+}
+
 func run() error {
 	filename := ""
 	rootCmd := &cobra.Command{
@@ -74,6 +118,18 @@ func run() error {
 	)
 	rootCmd.MarkPersistentFlagRequired("filename")
 
+	out := ""
+	pcapngExportCmd := &cobra.Command{
+		Use:   "export-pcapng",
+		Short: "Export a `*.replay.bin` file to pcapng, viewable in Wireshark",
+		RunE: func(_ *cobra.Command, _ []string) error {
+			return runPcapngExport(filename, out)
+		},
+	}
+	pcapngExportCmd.Flags().StringVar(&out, "out", "", "Filename to write the `*.pcapng` file to")
+	pcapngExportCmd.MarkFlagRequired("out")
+	rootCmd.AddCommand(pcapngExportCmd)
+
 	return rootCmd.Execute()
 }
 
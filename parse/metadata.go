@@ -15,6 +15,7 @@ package parse
 import (
 	"bufio"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -22,9 +23,39 @@ import (
 	"time"
 )
 
+// ErrParsingIP is returned when an address "row" in a replay log contains a
+// host that cannot be parsed as an IP address.
+var ErrParsingIP = errors.New("parse: error parsing IP")
+
+// Direction identifies which leg of a proxied connection a `TCPPacket` was
+// captured from.
+type Direction byte
+
+const (
+	// DirectionClientToServer identifies a packet sent by the frontend
+	// (client) to the backend (server).
+	DirectionClientToServer Direction = 0
+	// DirectionServerToClient identifies a packet sent by the backend
+	// (server) to the frontend (client).
+	DirectionServerToClient Direction = 1
+)
+
+// String renders a Direction for display, e.g. in `parse-example` output.
+func (d Direction) String() string {
+	switch d {
+	case DirectionClientToServer:
+		return "client->server"
+	case DirectionServerToClient:
+		return "server->client"
+	default:
+		return fmt.Sprintf("Direction(%d)", byte(d))
+	}
+}
+
 // TCPPacket represents a "row" from a replay file containing a TCP packet
 // and associated metadata.
 type TCPPacket struct {
+	Direction  Direction
 	Timestamp  time.Time
 	ClientAddr Addr
 	ServerAddr Addr
@@ -34,6 +65,14 @@ type TCPPacket struct {
 // Read reads and parses the next "row" in a replay log into the
 // current receiver.
 func (tp *TCPPacket) Read(br *bufio.Reader) (bytesRead int, err error) {
+	var dirByte byte
+	dirByte, err = br.ReadByte()
+	if err != nil {
+		return
+	}
+	bytesRead++
+	tp.Direction = Direction(dirByte)
+
 	var tsBytes [8]byte
 	n, err := io.ReadFull(br, tsBytes[:])
 	bytesRead += n
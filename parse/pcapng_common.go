@@ -0,0 +1,111 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parse
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// pcapng block type identifiers.
+//
+// See: https://pcapng.com/
+const (
+	pcapNgBlockSectionHeader      uint32 = 0x0A0D0D0A
+	pcapNgBlockInterfaceDescr     uint32 = 0x00000001
+	pcapNgBlockEnhancedPacket     uint32 = 0x00000006
+	pcapNgBlockNameResolution     uint32 = 0x00000004
+	pcapNgByteOrderMagic          uint32 = 0x1A2B3C4D
+	pcapNgOptionEndOfOpt          uint16 = 0
+	pcapNgOptionIfTSResol         uint16 = 9
+	pcapNgNameResolutionRecordIP4 uint16 = 1
+	pcapNgNameResolutionRecordIP6 uint16 = 2
+	pcapNgNameResolutionEnd       uint16 = 0
+)
+
+// TCP flag bits set on every segment `PcapNgWriter` synthesizes: PSH so a
+// packet dissector treats each one as deliverable application data, ACK
+// since a handshake is never synthesized and every segment is logically
+// part of an established connection.
+const (
+	tcpFlagACK byte = 0x10
+	tcpFlagPSH byte = 0x08
+)
+
+// linkTypeRaw is `LINKTYPE_RAW`: a bare IP packet with no link-layer header,
+// used by `PcapNgWriter` since it synthesizes IPv4/IPv6 + TCP headers
+// directly from a `TCPPacket`'s metadata.
+const linkTypeRaw uint32 = 101
+
+// linkTypeEthernet and linkTypeLinuxSLL are the other common link types
+// `NewPcapNgStream` accepts from captures produced by `tcpdump`.
+const (
+	linkTypeEthernet uint32 = 1
+	linkTypeLinuxSLL uint32 = 113
+)
+
+// ipProtocolTCP is the IPv4/IPv6 "next header"/"protocol" value for TCP.
+const ipProtocolTCP = 6
+
+// ErrPcapNg is returned when a pcapng file cannot be parsed, e.g. a
+// truncated or unsupported block.
+var ErrPcapNg = errors.New("parse: malformed pcapng data")
+
+// maxPcapNgBlockLen caps the Block Total Length `readBlockBody` trusts
+// before allocating, so a corrupted or adversarial capture with a bogus
+// length (e.g. 0xFFFFFFF0) fails with `ErrPcapNg` instead of driving a
+// multi-gigabyte allocation. 16 MiB is generous for any single pcapng
+// block a real capture tool produces.
+const maxPcapNgBlockLen uint32 = 16 << 20
+
+// ifTSResolNanoseconds is the `if_tsresol` option value for a resolution of
+// 10^-9 seconds (nanoseconds): the high bit is unset, so the byte itself is
+// the power-of-ten exponent.
+const ifTSResolNanoseconds byte = 9
+
+// parseIPBytes parses a host string (as produced by `Addr.IP.String()`)
+// into its raw 4-byte (IPv4) or 16-byte (IPv6) form.
+func parseIPBytes(host string) ([]byte, error) {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("%w; invalid host %q", ErrPcapNg, host)
+	}
+
+	if v4 := ip.To4(); v4 != nil {
+		return v4, nil
+	}
+
+	return ip.To16(), nil
+}
+
+// internetChecksum computes the IP/TCP "Internet checksum" (RFC 1071) of
+// `data`: the ones'-complement of the ones'-complement sum of its 16-bit
+// big-endian words, with the last word zero-padded if `data` has an odd
+// length.
+func internetChecksum(data []byte) uint16 {
+	var sum uint32
+	n := len(data)
+	for i := 0; i+1 < n; i += 2 {
+		sum += uint32(data[i])<<8 | uint32(data[i+1])
+	}
+	if n%2 == 1 {
+		sum += uint32(data[n-1]) << 8
+	}
+
+	for sum > 0xFFFF {
+		sum = (sum & 0xFFFF) + (sum >> 16)
+	}
+
+	return ^uint16(sum)
+}
@@ -0,0 +1,506 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parse
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/jackc/pgproto3/v2"
+
+	"github.com/dhermes/tcp-replay-reverse-proxy/postgres"
+)
+
+// sectionHeaderMagic is the Section Header Block's Block Type field,
+// 0x0A0D0D0A: a byte sequence that reads the same in either byte order, so
+// it can be recognized before a section's byte order is known.
+var sectionHeaderMagic = [4]byte{0x0A, 0x0D, 0x0D, 0x0A}
+
+// ifaceInfo is the subset of an Interface Description Block `PcapNgStream`
+// needs to decode the Enhanced Packet Blocks that reference it.
+type ifaceInfo struct {
+	linkType        uint32
+	tsResolExponent byte
+}
+
+// PcapNgStream reads a pcapng file, such as one produced by `tcpdump` or by
+// `PcapNgWriter`, reassembling each TCP flow it contains (in the presence of
+// out-of-order segments and retransmissions) into the same ordered
+// `TCPPacket` shape `ReplayLogStream` produces, so replay tooling can
+// consume either source interchangeably.
+//
+// A PcapNgStream is not safe for concurrent use.
+type PcapNgStream struct {
+	r          *bufio.Reader
+	byteOrder  binary.ByteOrder
+	interfaces []ifaceInfo
+	flows      map[string]*tcpFlow
+	pending    []*TCPPacket
+	cp         *postgres.ConnectionParser
+}
+
+// NewPcapNgStream creates a PcapNgStream that wraps a reader. The byte order
+// of multi-byte block-framing fields (block lengths, interface IDs,
+// timestamps, option lengths) is determined per-section from the first
+// Section Header Block's Byte-Order Magic field, since a real capture is
+// written in the capturing host's native order, not necessarily big-endian;
+// `byteOrder` defaults to big-endian only until that first block is read.
+func NewPcapNgStream(r io.Reader) *PcapNgStream {
+	return &PcapNgStream{
+		r:         bufio.NewReader(r),
+		byteOrder: binary.BigEndian,
+		flows:     map[string]*tcpFlow{},
+		cp:        postgres.NewConnectionParser(),
+	}
+}
+
+// Next produces the next reassembled `*TCPPacket` in the stream, reading
+// and discarding as many pcapng blocks as needed until a TCP segment yields
+// new, in-order application data.
+func (ps *PcapNgStream) Next() (*TCPPacket, error) {
+	for len(ps.pending) == 0 {
+		if err := ps.readBlock(); err != nil {
+			return nil, err
+		}
+	}
+
+	tp := ps.pending[0]
+	ps.pending = ps.pending[1:]
+	return tp, nil
+}
+
+// NextMessage produces the next `*TCPPacket` in the stream along with its
+// decoded `pgproto3.Message`, exactly as `ReplayLogStream.NextMessage` does.
+func (ps *PcapNgStream) NextMessage() (*TCPPacket, pgproto3.Message, error) {
+	tp, err := ps.Next()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if tp.Direction == DirectionServerToClient {
+		bm, err := postgres.ParseBackendChunk(tp.Chunk)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		ps.cp.ObserveBackendMessage(bm)
+
+		return tp, bm, nil
+	}
+
+	fm, err := ps.cp.ParseFrontendChunk(tp.Chunk)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return tp, fm, nil
+}
+
+// readBlock reads and dispatches a single pcapng block, appending zero or
+// more reassembled packets to `ps.pending`. A Section Header Block is
+// recognized from its byte-order-independent Block Type field before
+// `ps.byteOrder` is used to decode anything else, since it's what
+// determines `ps.byteOrder` for the section it opens.
+func (ps *PcapNgStream) readBlock() error {
+	peeked, err := ps.r.Peek(8)
+	if err != nil {
+		return err
+	}
+
+	if bytes.Equal(peeked[0:4], sectionHeaderMagic[:]) {
+		return ps.readSectionHeaderBlock()
+	}
+
+	return ps.readBlockBody(ps.byteOrder.Uint32(peeked[0:4]), ps.byteOrder.Uint32(peeked[4:8]))
+}
+
+// readSectionHeaderBlock reads a Section Header Block. Its Byte-Order Magic
+// field sits at a fixed 8-byte offset from the start of the block
+// regardless of which order the preceding Block Total Length is itself
+// encoded in, so it can be peeked and used to fix `ps.byteOrder` for this
+// section before that length is interpreted.
+func (ps *PcapNgStream) readSectionHeaderBlock() error {
+	prefix, err := ps.r.Peek(12)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPcapNg, err)
+	}
+
+	switch magic := prefix[8:12]; {
+	case binary.BigEndian.Uint32(magic) == pcapNgByteOrderMagic:
+		ps.byteOrder = binary.BigEndian
+	case binary.LittleEndian.Uint32(magic) == pcapNgByteOrderMagic:
+		ps.byteOrder = binary.LittleEndian
+	default:
+		return fmt.Errorf("%w; unrecognized byte-order magic", ErrPcapNg)
+	}
+
+	return ps.readBlockBody(pcapNgBlockSectionHeader, ps.byteOrder.Uint32(prefix[4:8]))
+}
+
+// readBlockBody consumes the block whose type and total length were already
+// peeked (and, for everything but a Section Header Block, decoded using
+// `ps.byteOrder`), then dispatches on `blockType`.
+func (ps *PcapNgStream) readBlockBody(blockType, totalLen uint32) error {
+	if totalLen < 12 {
+		return fmt.Errorf("%w; block length %d too small", ErrPcapNg, totalLen)
+	}
+	if totalLen > maxPcapNgBlockLen {
+		return fmt.Errorf("%w; block length %d exceeds %d-byte maximum", ErrPcapNg, totalLen, maxPcapNgBlockLen)
+	}
+
+	if _, err := ps.r.Discard(8); err != nil {
+		return fmt.Errorf("%w: %v", ErrPcapNg, err)
+	}
+
+	body := make([]byte, totalLen-12)
+	if _, err := io.ReadFull(ps.r, body); err != nil {
+		return fmt.Errorf("%w: %v", ErrPcapNg, err)
+	}
+
+	trailer := make([]byte, 4)
+	if _, err := io.ReadFull(ps.r, trailer); err != nil {
+		return fmt.Errorf("%w: %v", ErrPcapNg, err)
+	}
+	if ps.byteOrder.Uint32(trailer) != totalLen {
+		return fmt.Errorf("%w; mismatched block length trailer", ErrPcapNg)
+	}
+
+	switch blockType {
+	case pcapNgBlockSectionHeader:
+		// A new section restarts interface numbering.
+		ps.interfaces = nil
+		return nil
+	case pcapNgBlockInterfaceDescr:
+		return ps.handleInterfaceDescription(body)
+	case pcapNgBlockEnhancedPacket:
+		return ps.handleEnhancedPacket(body)
+	default:
+		// Name Resolution Blocks and any other block type carry no TCP
+		// payload data.
+		return nil
+	}
+}
+
+// handleInterfaceDescription records the link type and timestamp
+// resolution of a newly-described interface.
+func (ps *PcapNgStream) handleInterfaceDescription(body []byte) error {
+	if len(body) < 8 {
+		return fmt.Errorf("%w; truncated interface description block", ErrPcapNg)
+	}
+
+	iface := ifaceInfo{
+		linkType:        uint32(ps.byteOrder.Uint16(body[0:2])),
+		tsResolExponent: 6, // tcpdump's default: microsecond resolution
+	}
+	for _, opt := range parsePcapNgOptions(body[8:], ps.byteOrder) {
+		if opt.code != pcapNgOptionIfTSResol || len(opt.value) < 1 {
+			continue
+		}
+		if opt.value[0]&0x80 != 0 {
+			return fmt.Errorf("%w; binary (power-of-2) if_tsresol is not supported", ErrPcapNg)
+		}
+		iface.tsResolExponent = opt.value[0]
+	}
+
+	ps.interfaces = append(ps.interfaces, iface)
+	return nil
+}
+
+// handleEnhancedPacket strips the link-layer and IP/TCP headers off of a
+// captured frame and feeds the resulting segment into the flow it belongs
+// to, queuing any newly in-order application data as a `*TCPPacket`.
+// Frames that aren't TCP/IP (ARP, etc.) or that reference an unknown
+// interface are skipped rather than failing the whole stream.
+func (ps *PcapNgStream) handleEnhancedPacket(body []byte) error {
+	if len(body) < 20 {
+		return fmt.Errorf("%w; truncated enhanced packet block", ErrPcapNg)
+	}
+
+	ifaceID := ps.byteOrder.Uint32(body[0:4])
+	if int(ifaceID) >= len(ps.interfaces) {
+		return nil
+	}
+	iface := ps.interfaces[ifaceID]
+
+	tsHigh := ps.byteOrder.Uint32(body[4:8])
+	tsLow := ps.byteOrder.Uint32(body[8:12])
+	capturedLen := ps.byteOrder.Uint32(body[12:16])
+	if uint32(len(body)-20) < capturedLen {
+		return fmt.Errorf("%w; truncated packet data", ErrPcapNg)
+	}
+	data := body[20 : 20+capturedLen]
+
+	ipPayload, err := stripLinkLayer(data, iface.linkType)
+	if err != nil {
+		return nil
+	}
+
+	src, dst, protocol, tcpSegment, err := parseIPHeader(ipPayload)
+	if err != nil || protocol != ipProtocolTCP {
+		return nil
+	}
+
+	srcPort, dstPort, seq, payload, err := parseTCPSegment(tcpSegment)
+	if err != nil {
+		return nil
+	}
+
+	srcAddr := Addr{IP: src, Port: srcPort}
+	dstAddr := Addr{IP: dst, Port: dstPort}
+
+	key := sessionKeyFor(srcAddr, dstAddr)
+	flow, ok := ps.flows[key]
+	if !ok {
+		flow = &tcpFlow{}
+		ps.flows[key] = flow
+	}
+
+	dir := flow.direction(srcAddr, dstAddr)
+	ts := decodeTimestamp(tsHigh, tsLow, iface.tsResolExponent)
+	for _, chunk := range flow.addSegment(dir, seq, payload) {
+		ps.pending = append(ps.pending, &TCPPacket{
+			Direction:  dir,
+			Timestamp:  ts,
+			ClientAddr: flow.client,
+			ServerAddr: flow.server,
+			Chunk:      chunk,
+		})
+	}
+
+	return nil
+}
+
+// stripLinkLayer strips the link-layer framing off of `data`, returning the
+// IP packet it carries.
+func stripLinkLayer(data []byte, linkType uint32) ([]byte, error) {
+	switch linkType {
+	case linkTypeRaw:
+		return data, nil
+	case linkTypeEthernet:
+		if len(data) < 14 {
+			return nil, fmt.Errorf("%w; truncated ethernet frame", ErrPcapNg)
+		}
+		if binary.BigEndian.Uint16(data[12:14]) == 0x8100 { // 802.1Q VLAN tag
+			if len(data) < 18 {
+				return nil, fmt.Errorf("%w; truncated 802.1Q ethernet frame", ErrPcapNg)
+			}
+			return data[18:], nil
+		}
+		return data[14:], nil
+	case linkTypeLinuxSLL:
+		if len(data) < 16 {
+			return nil, fmt.Errorf("%w; truncated Linux cooked capture frame", ErrPcapNg)
+		}
+		return data[16:], nil
+	default:
+		return nil, fmt.Errorf("%w; unsupported link type %d", ErrPcapNg, linkType)
+	}
+}
+
+// parseIPHeader parses an IPv4 or IPv6 header, returning the source and
+// destination addresses, the next-header/protocol value, and the payload.
+// The payload is truncated to the header's declared length (IPv4 Total
+// Length, IPv6 Payload Length) rather than running to the end of `data`,
+// since a captured frame may carry trailing link-layer padding (e.g.
+// Ethernet's 64-byte minimum frame size) that isn't part of the packet.
+// IPv6 extension headers are not walked; a TCP segment behind one is not
+// recognized.
+func parseIPHeader(data []byte) (src, dst net.IP, protocol byte, payload []byte, err error) {
+	if len(data) == 0 {
+		return nil, nil, 0, nil, fmt.Errorf("%w; empty IP payload", ErrPcapNg)
+	}
+
+	switch version := data[0] >> 4; version {
+	case 4:
+		ihl := int(data[0]&0x0F) * 4
+		if ihl < 20 || len(data) < ihl {
+			return nil, nil, 0, nil, fmt.Errorf("%w; truncated IPv4 header", ErrPcapNg)
+		}
+		totalLen := int(binary.BigEndian.Uint16(data[2:4]))
+		if totalLen < ihl || totalLen > len(data) {
+			return nil, nil, 0, nil, fmt.Errorf("%w; invalid IPv4 total length", ErrPcapNg)
+		}
+		return data[12:16], data[16:20], data[9], data[ihl:totalLen], nil
+	case 6:
+		if len(data) < 40 {
+			return nil, nil, 0, nil, fmt.Errorf("%w; truncated IPv6 header", ErrPcapNg)
+		}
+		payloadLen := int(binary.BigEndian.Uint16(data[4:6]))
+		if 40+payloadLen > len(data) {
+			return nil, nil, 0, nil, fmt.Errorf("%w; invalid IPv6 payload length", ErrPcapNg)
+		}
+		return data[8:24], data[24:40], data[6], data[40 : 40+payloadLen], nil
+	default:
+		return nil, nil, 0, nil, fmt.Errorf("%w; unrecognized IP version %d", ErrPcapNg, version)
+	}
+}
+
+// parseTCPSegment parses a TCP header (options are skipped, not decoded),
+// returning the source/destination ports, the sequence number, and the
+// segment's payload.
+func parseTCPSegment(data []byte) (srcPort, dstPort uint16, seq uint32, payload []byte, err error) {
+	if len(data) < 20 {
+		return 0, 0, 0, nil, fmt.Errorf("%w; truncated TCP header", ErrPcapNg)
+	}
+
+	dataOffset := int(data[12]>>4) * 4
+	if dataOffset < 20 || dataOffset > len(data) {
+		return 0, 0, 0, nil, fmt.Errorf("%w; invalid TCP data offset", ErrPcapNg)
+	}
+
+	srcPort = binary.BigEndian.Uint16(data[0:2])
+	dstPort = binary.BigEndian.Uint16(data[2:4])
+	seq = binary.BigEndian.Uint32(data[4:8])
+	return srcPort, dstPort, seq, data[dataOffset:], nil
+}
+
+// decodeTimestamp combines an Enhanced Packet Block's high/low timestamp
+// words into a `time.Time`, per the interface's `if_tsresol`.
+func decodeTimestamp(tsHigh, tsLow uint32, resolExponent byte) time.Time {
+	units := uint64(tsHigh)<<32 | uint64(tsLow)
+
+	unitsPerSecond := uint64(1)
+	for i := byte(0); i < resolExponent; i++ {
+		unitsPerSecond *= 10
+	}
+
+	sec := units / unitsPerSecond
+	nsec := (units % unitsPerSecond) * (1_000_000_000 / unitsPerSecond)
+	return time.Unix(int64(sec), int64(nsec)).UTC()
+}
+
+// pcapNgOption is a single decoded pcapng option TLV.
+type pcapNgOption struct {
+	code  uint16
+	value []byte
+}
+
+// parsePcapNgOptions decodes the TLV-encoded options trailing a block body,
+// stopping at `pcapNgOptionEndOfOpt` or the first malformed option. The
+// option code/length fields are block-framing data, so they're decoded in
+// `order`, the section's byte order, same as the rest of the block.
+func parsePcapNgOptions(data []byte, order binary.ByteOrder) []pcapNgOption {
+	var opts []pcapNgOption
+	for len(data) >= 4 {
+		code := order.Uint16(data[0:2])
+		if code == pcapNgOptionEndOfOpt {
+			break
+		}
+
+		length := int(order.Uint16(data[2:4]))
+		if 4+length > len(data) {
+			break
+		}
+		opts = append(opts, pcapNgOption{code: code, value: data[4 : 4+length]})
+
+		advance := 4 + length
+		if rem := length % 4; rem != 0 {
+			advance += 4 - rem
+		}
+		if advance > len(data) {
+			break
+		}
+		data = data[advance:]
+	}
+	return opts
+}
+
+// sessionKeyFor returns a key that identifies `a`/`b`'s TCP session
+// regardless of which address is the source and which is the destination,
+// so both directions of a flow map to the same `tcpFlow`.
+func sessionKeyFor(a, b Addr) string {
+	as, bs := a.String(), b.String()
+	if as < bs {
+		return as + "|" + bs
+	}
+	return bs + "|" + as
+}
+
+// tcpFlow reassembles one TCP session's segments, in each direction
+// independently, into ordered application data.
+type tcpFlow struct {
+	client, server Addr
+	assigned       bool
+	started        [2]bool
+	nextSeq        [2]uint32
+	outOfOrder     [2]map[uint32][]byte
+}
+
+// direction assigns client/server roles to `f` from the first packet
+// observed for this flow, then reports which leg `src`/`dst` belongs to.
+// A real capture may not begin with the opening SYN (e.g. a mid-stream
+// capture start), so this is a best-effort label, not a protocol guarantee;
+// it only affects which `TCPPacket.ClientAddr`/`ServerAddr` is which, not
+// the reassembly itself.
+func (f *tcpFlow) direction(src, dst Addr) Direction {
+	if !f.assigned {
+		f.client, f.server = src, dst
+		f.assigned = true
+	}
+
+	if src.String() == f.client.String() {
+		return DirectionClientToServer
+	}
+	return DirectionServerToClient
+}
+
+// addSegment folds a newly-observed segment into the reassembly state for
+// `dir`, returning, in order, any application data that is now contiguous
+// from the start of the stream. Segments fully covered by data already
+// reassembled are dropped as retransmissions; a partially-overlapping
+// segment is trimmed to its new tail.
+func (f *tcpFlow) addSegment(dir Direction, seq uint32, payload []byte) [][]byte {
+	if len(payload) == 0 {
+		return nil
+	}
+
+	idx := int(dir)
+	if !f.started[idx] {
+		f.started[idx] = true
+		f.nextSeq[idx] = seq
+		f.outOfOrder[idx] = map[uint32][]byte{}
+	}
+
+	if seqBefore(seq, f.nextSeq[idx]) {
+		overlap := f.nextSeq[idx] - seq
+		if overlap >= uint32(len(payload)) {
+			return nil // fully-seen retransmission
+		}
+		seq, payload = f.nextSeq[idx], payload[overlap:]
+	}
+	f.outOfOrder[idx][seq] = payload
+
+	var out [][]byte
+	for {
+		chunk, ok := f.outOfOrder[idx][f.nextSeq[idx]]
+		if !ok {
+			break
+		}
+		out = append(out, chunk)
+		delete(f.outOfOrder[idx], f.nextSeq[idx])
+		f.nextSeq[idx] += uint32(len(chunk))
+	}
+	return out
+}
+
+// seqBefore reports whether `a` precedes `b` in TCP sequence-space order,
+// per the spec's modular comparison (mod 2^32); it assumes no in-flight
+// window ever spans more than half the sequence space.
+func seqBefore(a, b uint32) bool {
+	return int32(a-b) < 0
+}
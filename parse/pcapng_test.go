@@ -0,0 +1,218 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parse
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSeqBefore(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b uint32
+		want bool
+	}{
+		{name: "equal", a: 100, b: 100, want: false},
+		{name: "a before b", a: 100, b: 200, want: true},
+		{name: "a after b", a: 200, b: 100, want: false},
+		{name: "wraps around zero, a before b", a: 0xFFFFFFFE, b: 2, want: true},
+		{name: "wraps around zero, a after b", a: 2, b: 0xFFFFFFFE, want: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := seqBefore(tc.a, tc.b); got != tc.want {
+				t.Errorf("seqBefore(%d, %d) = %v, want %v", tc.a, tc.b, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTCPFlowAddSegment(t *testing.T) {
+	f := &tcpFlow{}
+	const dir = DirectionClientToServer
+
+	// The first segment observed establishes the flow's starting sequence
+	// number, so it is immediately in order.
+	out := f.addSegment(dir, 5, []byte("hello"))
+	want := [][]byte{[]byte("hello")}
+	if !reflect.DeepEqual(out, want) {
+		t.Fatalf("addSegment(5, %q) = %v, want %v", "hello", out, want)
+	}
+
+	// Out-of-order: this segment's sequence number is past nextSeq, so it
+	// must be held back rather than returned.
+	if out := f.addSegment(dir, 15, []byte("!!!")); out != nil {
+		t.Fatalf("addSegment(15, %q) = %v, want nil (out of order)", "!!!", out)
+	}
+
+	// Filling the gap releases the held-back segment too, in order.
+	out = f.addSegment(dir, 10, []byte("world"))
+	want = [][]byte{[]byte("world"), []byte("!!!")}
+	if !reflect.DeepEqual(out, want) {
+		t.Fatalf("addSegment(10, %q) = %v, want %v", "world", out, want)
+	}
+
+	// Fully-seen retransmission of the first segment is dropped.
+	if out := f.addSegment(dir, 5, []byte("hello")); out != nil {
+		t.Fatalf("retransmission addSegment(5, %q) = %v, want nil", "hello", out)
+	}
+
+	// A partially-overlapping segment is trimmed to its new tail.
+	out = f.addSegment(dir, 15, []byte("!!!extra"))
+	want = [][]byte{[]byte("extra")}
+	if !reflect.DeepEqual(out, want) {
+		t.Fatalf("overlapping addSegment(15, %q) = %v, want %v", "!!!extra", out, want)
+	}
+
+	// Sequence-space wraparound: nextSeq rolls past 0xFFFFFFFF back to 0.
+	f2 := &tcpFlow{}
+	out = f2.addSegment(dir, 0xFFFFFFFE, []byte("ab"))
+	want = [][]byte{[]byte("ab")}
+	if !reflect.DeepEqual(out, want) {
+		t.Fatalf("addSegment(0xFFFFFFFE, %q) = %v, want %v", "ab", out, want)
+	}
+	out = f2.addSegment(dir, 0, []byte("cd"))
+	want = [][]byte{[]byte("cd")}
+	if !reflect.DeepEqual(out, want) {
+		t.Fatalf("addSegment(0, %q) after wraparound = %v, want %v", "cd", out, want)
+	}
+}
+
+func TestInternetChecksum(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want uint16
+	}{
+		// RFC 1071 section 3 worked example.
+		{
+			name: "RFC 1071 example",
+			data: []byte{0x00, 0x01, 0xf2, 0x03, 0xf4, 0xf5, 0xf6, 0xf7},
+			want: 0x220d,
+		},
+		{name: "empty", data: nil, want: 0xffff},
+		{
+			name: "odd length is zero-padded",
+			data: []byte{0x00, 0x01, 0xf2},
+			want: 0x0dfe,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := internetChecksum(tc.data); got != tc.want {
+				t.Errorf("internetChecksum(% x) = 0x%04x, want 0x%04x", tc.data, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPcapNgWriterStreamRoundTrip(t *testing.T) {
+	packets := []*TCPPacket{
+		{
+			Direction:  DirectionClientToServer,
+			Timestamp:  time.Unix(1_700_000_000, 123_000_000).UTC(),
+			ClientAddr: Addr{IP: net.ParseIP("10.0.0.1"), Port: 54321},
+			ServerAddr: Addr{IP: net.ParseIP("10.0.0.2"), Port: 5432},
+			Chunk:      []byte("hello from the client"),
+		},
+		{
+			Direction:  DirectionServerToClient,
+			Timestamp:  time.Unix(1_700_000_001, 456_000_000).UTC(),
+			ClientAddr: Addr{IP: net.ParseIP("10.0.0.1"), Port: 54321},
+			ServerAddr: Addr{IP: net.ParseIP("10.0.0.2"), Port: 5432},
+			Chunk:      []byte("hello from the server"),
+		},
+		{
+			Direction:  DirectionClientToServer,
+			Timestamp:  time.Unix(1_700_000_002, 0).UTC(),
+			ClientAddr: Addr{IP: net.ParseIP("fd00::1"), Port: 11111},
+			ServerAddr: Addr{IP: net.ParseIP("fd00::2"), Port: 5432},
+			Chunk:      []byte("ipv6 client chunk"),
+		},
+	}
+
+	var buf bytes.Buffer
+	pw, err := NewPcapNgWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewPcapNgWriter() error = %v", err)
+	}
+	for _, tp := range packets {
+		if err := pw.WriteTCPPacket(tp); err != nil {
+			t.Fatalf("WriteTCPPacket(%+v) error = %v", tp, err)
+		}
+	}
+	if err := pw.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	ps := NewPcapNgStream(&buf)
+	for i, want := range packets {
+		got, err := ps.Next()
+		if err != nil {
+			t.Fatalf("Next() #%d error = %v", i, err)
+		}
+
+		if got.Direction != want.Direction {
+			t.Errorf("packet #%d Direction = %v, want %v", i, got.Direction, want.Direction)
+		}
+		if got.ClientAddr.String() != want.ClientAddr.String() {
+			t.Errorf("packet #%d ClientAddr = %v, want %v", i, got.ClientAddr, want.ClientAddr)
+		}
+		if got.ServerAddr.String() != want.ServerAddr.String() {
+			t.Errorf("packet #%d ServerAddr = %v, want %v", i, got.ServerAddr, want.ServerAddr)
+		}
+		if !bytes.Equal(got.Chunk, want.Chunk) {
+			t.Errorf("packet #%d Chunk = %q, want %q", i, got.Chunk, want.Chunk)
+		}
+		if !got.Timestamp.Equal(want.Timestamp) {
+			t.Errorf("packet #%d Timestamp = %v, want %v", i, got.Timestamp, want.Timestamp)
+		}
+	}
+
+	if _, err := ps.Next(); err == nil {
+		t.Fatal("Next() after last packet = nil error, want EOF-like error")
+	}
+}
+
+// TestParsePcapNgOptionsTruncatedPadding exercises an Interface Description
+// Block whose trailing option declares a length that fits the block body
+// unpadded, but whose 4-byte-boundary padding would read past the end of
+// it. `parsePcapNgOptions` must stop rather than slice out of bounds.
+func TestParsePcapNgOptionsTruncatedPadding(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := NewPcapNgWriter(&buf); err != nil {
+		t.Fatalf("NewPcapNgWriter() error = %v", err)
+	}
+
+	idbBody := make([]byte, 8)
+	binary.BigEndian.PutUint16(idbBody[0:2], uint16(linkTypeRaw))
+	// A length-1 option needs 3 bytes of 4-byte-boundary padding, but the
+	// block body ends immediately after the option's single value byte.
+	idbBody = append(idbBody, encodePcapNgOption(pcapNgOptionIfTSResol, []byte{0xAA})...)
+	idbBody = idbBody[:len(idbBody)-3]
+	if err := writePcapNgBlock(&buf, pcapNgBlockInterfaceDescr, idbBody); err != nil {
+		t.Fatalf("writePcapNgBlock() error = %v", err)
+	}
+
+	ps := NewPcapNgStream(&buf)
+	if _, err := ps.Next(); err == nil {
+		t.Fatal("Next() with truncated option padding = nil error, want non-nil error")
+	}
+}
@@ -0,0 +1,342 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parse
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+// flowKey identifies one TCP session (unordered: client->server and
+// server->client chunks of the same session share a key).
+type flowKey struct {
+	client string
+	server string
+}
+
+// PcapNgWriter serializes `TCPPacket`s as a standard pcapng file, suitable
+// for opening directly in Wireshark. IPv4/IPv6 and TCP headers are
+// synthesized from each packet's `ClientAddr`/`ServerAddr`, and `Timestamp`
+// becomes the Enhanced Packet Block timestamp, at nanosecond resolution.
+//
+// A PcapNgWriter is not safe for concurrent use.
+type PcapNgWriter struct {
+	w            io.Writer
+	interfaces   map[flowKey]uint32
+	hostnames    map[string]bool
+	sequences    map[string]uint32
+	nextIfaceNum uint32
+}
+
+// NewPcapNgWriter creates a PcapNgWriter, writing the Section Header Block
+// immediately.
+func NewPcapNgWriter(w io.Writer) (*PcapNgWriter, error) {
+	pw := &PcapNgWriter{
+		w:          w,
+		interfaces: map[flowKey]uint32{},
+		hostnames:  map[string]bool{},
+		sequences:  map[string]uint32{},
+	}
+
+	if err := pw.writeSectionHeader(); err != nil {
+		return nil, err
+	}
+
+	return pw, nil
+}
+
+func (pw *PcapNgWriter) writeSectionHeader() error {
+	body := make([]byte, 16)
+	binary.BigEndian.PutUint32(body[0:4], pcapNgByteOrderMagic)
+	binary.BigEndian.PutUint16(body[4:6], 1)                   // major version
+	binary.BigEndian.PutUint16(body[6:8], 0)                   // minor version
+	binary.BigEndian.PutUint64(body[8:16], 0xFFFFFFFFFFFFFFFF) // section length: unknown
+
+	return writePcapNgBlock(pw.w, pcapNgBlockSectionHeader, body)
+}
+
+// WriteTCPPacket appends `tp` as an Enhanced Packet Block, synthesizing an
+// Interface Description Block the first time its (client, server) pair is
+// seen.
+func (pw *PcapNgWriter) WriteTCPPacket(tp *TCPPacket) error {
+	fk := flowKey{client: tp.ClientAddr.String(), server: tp.ServerAddr.String()}
+	ifaceID, ok := pw.interfaces[fk]
+	if !ok {
+		var err error
+		ifaceID, err = pw.writeInterfaceDescription()
+		if err != nil {
+			return err
+		}
+		pw.interfaces[fk] = ifaceID
+	}
+
+	pw.hostnames[tp.ClientAddr.IP.String()] = true
+	pw.hostnames[tp.ServerAddr.IP.String()] = true
+
+	packetData, err := synthesizePacket(tp, pw.nextSeq(fk, tp.Direction), pw.nextSeq(fk, otherDirection(tp.Direction)))
+	if err != nil {
+		return err
+	}
+	pw.advanceSeq(fk, tp.Direction, len(tp.Chunk))
+
+	return pw.writeEnhancedPacket(ifaceID, tp.Timestamp.UnixNano(), packetData)
+}
+
+func otherDirection(d Direction) Direction {
+	if d == DirectionClientToServer {
+		return DirectionServerToClient
+	}
+	return DirectionClientToServer
+}
+
+// nextSeq returns the running TCP sequence number for `dir` on flow `fk`,
+// without advancing it; `advanceSeq` does that. This is only used to make the synthesized stream look
+// plausible in a TCP stream follow; it is not derived from any real capture.
+func (pw *PcapNgWriter) nextSeq(fk flowKey, dir Direction) uint32 {
+	key := fmt.Sprintf("%s|%s|%d", fk.client, fk.server, dir)
+	seq, ok := pw.sequences[key]
+	if !ok {
+		seq = 1
+		pw.sequences[key] = seq
+	}
+	return seq
+}
+
+func (pw *PcapNgWriter) advanceSeq(fk flowKey, dir Direction, n int) {
+	key := fmt.Sprintf("%s|%s|%d", fk.client, fk.server, dir)
+	pw.sequences[key] = pw.sequences[key] + uint32(n)
+}
+
+func (pw *PcapNgWriter) writeInterfaceDescription() (uint32, error) {
+	body := make([]byte, 8)
+	binary.BigEndian.PutUint16(body[0:2], uint16(linkTypeRaw))
+	binary.BigEndian.PutUint16(body[2:4], 0) // reserved
+	binary.BigEndian.PutUint32(body[4:8], 0) // snaplen: unlimited
+
+	body = append(body, encodePcapNgOption(pcapNgOptionIfTSResol, []byte{ifTSResolNanoseconds})...)
+	body = append(body, encodePcapNgOption(pcapNgOptionEndOfOpt, nil)...)
+
+	ifaceID := pw.nextIfaceNum
+	pw.nextIfaceNum++
+
+	return ifaceID, writePcapNgBlock(pw.w, pcapNgBlockInterfaceDescr, body)
+}
+
+func (pw *PcapNgWriter) writeEnhancedPacket(ifaceID uint32, unixNanos int64, data []byte) error {
+	header := make([]byte, 20)
+	binary.BigEndian.PutUint32(header[0:4], ifaceID)
+	binary.BigEndian.PutUint32(header[4:8], uint32(unixNanos>>32))
+	binary.BigEndian.PutUint32(header[8:12], uint32(unixNanos))
+	binary.BigEndian.PutUint32(header[12:16], uint32(len(data)))
+	binary.BigEndian.PutUint32(header[16:20], uint32(len(data)))
+
+	body := append(header, data...)
+	body = append(body, padding(len(data))...)
+
+	return writePcapNgBlock(pw.w, pcapNgBlockEnhancedPacket, body)
+}
+
+// Close writes a Name Resolution Block mapping every address seen by
+// `WriteTCPPacket` to a label, so Wireshark shows something meaningful
+// instead of a bare IP in the packet list.
+func (pw *PcapNgWriter) Close() error {
+	if len(pw.hostnames) == 0 {
+		return nil
+	}
+
+	var body []byte
+	i := 0
+	for host := range pw.hostnames {
+		record, err := encodeNameResolutionRecord(host, fmt.Sprintf("tcp-replay-host-%d", i))
+		if err != nil {
+			return err
+		}
+		body = append(body, record...)
+		i++
+	}
+
+	body = append(body, make([]byte, 4)...) // nrb_record_end
+	body = append(body, encodePcapNgOption(pcapNgOptionEndOfOpt, nil)...)
+
+	return writePcapNgBlock(pw.w, pcapNgBlockNameResolution, body)
+}
+
+func encodeNameResolutionRecord(host, name string) ([]byte, error) {
+	ip, err := parseIPBytes(host)
+	if err != nil {
+		return nil, err
+	}
+
+	nameBytes := append([]byte(name), 0)
+	payload := append(append([]byte{}, ip...), nameBytes...)
+
+	recordType := pcapNgNameResolutionRecordIP4
+	if len(ip) == 16 {
+		recordType = pcapNgNameResolutionRecordIP6
+	}
+
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint16(header[0:2], recordType)
+	binary.BigEndian.PutUint16(header[2:4], uint16(len(payload)))
+
+	record := append(header, payload...)
+	return append(record, padding(len(payload))...), nil
+}
+
+// encodePcapNgOption encodes a single TLV option, padded to a 4-byte
+// boundary.
+func encodePcapNgOption(code uint16, value []byte) []byte {
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint16(header[0:2], code)
+	binary.BigEndian.PutUint16(header[2:4], uint16(len(value)))
+
+	option := append(header, value...)
+	return append(option, padding(len(value))...)
+}
+
+// padding returns the zero bytes needed to round `n` up to a 4-byte
+// boundary.
+func padding(n int) []byte {
+	if rem := n % 4; rem != 0 {
+		return make([]byte, 4-rem)
+	}
+	return nil
+}
+
+// writePcapNgBlock frames `body` with a block type and the total-length
+// fields pcapng requires at both the start and end of every block.
+func writePcapNgBlock(w io.Writer, blockType uint32, body []byte) error {
+	totalLen := uint32(12 + len(body))
+
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.BigEndian, blockType); err != nil {
+		return err
+	}
+	if err := binary.Write(&buf, binary.BigEndian, totalLen); err != nil {
+		return err
+	}
+	buf.Write(body)
+	if err := binary.Write(&buf, binary.BigEndian, totalLen); err != nil {
+		return err
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// synthesizePacket builds a `LINKTYPE_RAW` frame carrying `tp.Chunk` as a
+// single TCP segment, synthesizing an IPv4 or IPv6 header (matching
+// whichever address family `tp`'s addresses belong to) plus a TCP header
+// stamped with `seq`/`ack`.
+func synthesizePacket(tp *TCPPacket, seq, ack uint32) ([]byte, error) {
+	src, dst := tp.ClientAddr, tp.ServerAddr
+	if tp.Direction == DirectionServerToClient {
+		src, dst = tp.ServerAddr, tp.ClientAddr
+	}
+
+	if srcIP, dstIP := src.IP.To4(), dst.IP.To4(); srcIP != nil && dstIP != nil {
+		return synthesizeIPv4Packet(srcIP, dstIP, src.Port, dst.Port, seq, ack, tp.Chunk), nil
+	}
+
+	srcIP, dstIP := src.IP.To16(), dst.IP.To16()
+	if srcIP == nil || dstIP == nil {
+		return nil, fmt.Errorf("%w; unrecognized address family for %s/%s", ErrPcapNg, src, dst)
+	}
+
+	return synthesizeIPv6Packet(srcIP, dstIP, src.Port, dst.Port, seq, ack, tp.Chunk), nil
+}
+
+// synthesizeIPv4Packet builds an IPv4 header followed by a TCP segment
+// carrying `payload`, with both header checksums filled in.
+func synthesizeIPv4Packet(src, dst net.IP, srcPort, dstPort uint16, seq, ack uint32, payload []byte) []byte {
+	segment := buildTCPSegment(srcPort, dstPort, seq, ack, payload)
+	setTCPChecksum(segment, pseudoHeaderIPv4(src, dst, len(segment)))
+
+	header := make([]byte, 20)
+	header[0] = 0x45 // version 4, 5 32-bit words, no options
+	binary.BigEndian.PutUint16(header[2:4], uint16(len(header)+len(segment)))
+	header[8] = 64 // TTL
+	header[9] = ipProtocolTCP
+	copy(header[12:16], src)
+	copy(header[16:20], dst)
+	binary.BigEndian.PutUint16(header[10:12], internetChecksum(header))
+
+	return append(header, segment...)
+}
+
+// synthesizeIPv6Packet builds an IPv6 header followed by a TCP segment
+// carrying `payload`. IPv6 has no header checksum of its own; only the TCP
+// checksum is computed.
+func synthesizeIPv6Packet(src, dst net.IP, srcPort, dstPort uint16, seq, ack uint32, payload []byte) []byte {
+	segment := buildTCPSegment(srcPort, dstPort, seq, ack, payload)
+	setTCPChecksum(segment, pseudoHeaderIPv6(src, dst, len(segment)))
+
+	header := make([]byte, 40)
+	header[0] = 0x60 // version 6, zero traffic class/flow label
+	binary.BigEndian.PutUint16(header[4:6], uint16(len(segment)))
+	header[6] = ipProtocolTCP
+	header[7] = 64 // hop limit
+	copy(header[8:24], src)
+	copy(header[24:40], dst)
+
+	return append(header, segment...)
+}
+
+// buildTCPSegment builds a TCP header (no options) with the checksum field
+// left zeroed, followed by `payload`.
+func buildTCPSegment(srcPort, dstPort uint16, seq, ack uint32, payload []byte) []byte {
+	header := make([]byte, 20)
+	binary.BigEndian.PutUint16(header[0:2], srcPort)
+	binary.BigEndian.PutUint16(header[2:4], dstPort)
+	binary.BigEndian.PutUint32(header[4:8], seq)
+	binary.BigEndian.PutUint32(header[8:12], ack)
+	header[12] = 5 << 4 // data offset: 5 32-bit words, no options
+	header[13] = tcpFlagPSH | tcpFlagACK
+	binary.BigEndian.PutUint16(header[14:16], 65535) // window size
+
+	return append(header, payload...)
+}
+
+// setTCPChecksum fills in `segment`'s checksum field in place, computed
+// over `pseudoHeader` (the IPv4/IPv6 pseudo-header) followed by `segment`
+// itself with the checksum field zeroed.
+func setTCPChecksum(segment, pseudoHeader []byte) {
+	segment[16], segment[17] = 0, 0
+	sum := internetChecksum(append(pseudoHeader, segment...))
+	binary.BigEndian.PutUint16(segment[16:18], sum)
+}
+
+// pseudoHeaderIPv4 builds the 12-byte IPv4 pseudo-header TCP checksums are
+// computed over.
+func pseudoHeaderIPv4(src, dst net.IP, tcpLength int) []byte {
+	ph := make([]byte, 12)
+	copy(ph[0:4], src)
+	copy(ph[4:8], dst)
+	ph[9] = ipProtocolTCP
+	binary.BigEndian.PutUint16(ph[10:12], uint16(tcpLength))
+	return ph
+}
+
+// pseudoHeaderIPv6 builds the 40-byte IPv6 pseudo-header TCP checksums are
+// computed over.
+func pseudoHeaderIPv6(src, dst net.IP, tcpLength int) []byte {
+	ph := make([]byte, 40)
+	copy(ph[0:16], src)
+	copy(ph[16:32], dst)
+	binary.BigEndian.PutUint32(ph[32:36], uint32(tcpLength))
+	ph[39] = ipProtocolTCP
+	return ph
+}
@@ -14,22 +14,63 @@ package parse
 
 import (
 	"bufio"
+	"errors"
+	"fmt"
 	"io"
+
+	"github.com/jackc/pgproto3/v2"
+
+	"github.com/dhermes/tcp-replay-reverse-proxy/postgres"
 )
 
+// FormatVersion is the current replay log format version, written as the
+// first byte of a stream, before any packet rows. It was bumped to 2 when
+// `TCPPacket.Direction` was introduced, so a reader expecting this version
+// fails fast against an older, unversioned (client-to-server only) replay
+// file instead of silently misparsing it.
+const FormatVersion byte = 2
+
+// ErrUnsupportedFormatVersion is returned when a stream's leading format
+// version byte doesn't match `FormatVersion`.
+var ErrUnsupportedFormatVersion = errors.New("parse: unsupported replay log format version")
+
 // ReplayLogStream parses an input stream of replay log "rows".
 type ReplayLogStream struct {
-	br *bufio.Reader
+	br          *bufio.Reader
+	cp          *postgres.ConnectionParser
+	versionRead bool
 }
 
 // NewReplayLogStream produces a ReplayLogStream that wraps a reader.
 func NewReplayLogStream(r io.Reader) *ReplayLogStream {
 	br := bufio.NewReader(r)
-	return &ReplayLogStream{br: br}
+	return &ReplayLogStream{br: br, cp: postgres.NewConnectionParser()}
+}
+
+// readVersion reads and validates the stream's leading format version byte,
+// done once per stream, lazily, on the first call to Next.
+func (rls *ReplayLogStream) readVersion() error {
+	v, err := rls.br.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	if v != FormatVersion {
+		return fmt.Errorf("%w; got %d, want %d", ErrUnsupportedFormatVersion, v, FormatVersion)
+	}
+
+	rls.versionRead = true
+	return nil
 }
 
 // Next produces the next parsed `*TCPPacket` in the stream.
 func (rls *ReplayLogStream) Next() (*TCPPacket, error) {
+	if !rls.versionRead {
+		if err := rls.readVersion(); err != nil {
+			return nil, err
+		}
+	}
+
 	tp := &TCPPacket{}
 	n, err := tp.Read(rls.br)
 	if err == io.EOF && n != 0 {
@@ -42,3 +83,35 @@ func (rls *ReplayLogStream) Next() (*TCPPacket, error) {
 
 	return tp, nil
 }
+
+// NextMessage produces the next `*TCPPacket` in the stream along with its
+// decoded `pgproto3.Message`. `tp.Direction` selects between
+// `postgres.ParseBackendChunk` and the stream's internal
+// `*postgres.ConnectionParser.ParseFrontendChunk`; every decoded backend
+// message is also fed back into that `ConnectionParser` via
+// `ObserveBackendMessage` so a later frontend `'p'` message can still be
+// disambiguated against the authentication handshake.
+func (rls *ReplayLogStream) NextMessage() (*TCPPacket, pgproto3.Message, error) {
+	tp, err := rls.Next()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if tp.Direction == DirectionServerToClient {
+		bm, err := postgres.ParseBackendChunk(tp.Chunk)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		rls.cp.ObserveBackendMessage(bm)
+
+		return tp, bm, nil
+	}
+
+	fm, err := rls.cp.ParseFrontendChunk(tp.Chunk)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return tp, fm, nil
+}
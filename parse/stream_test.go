@@ -0,0 +1,85 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parse
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgproto3/v2"
+)
+
+// buildRow assembles one replay log "row" in the wire format `TCPPacket.Read`
+// expects: the direction byte, an 8-byte big-endian nanosecond timestamp,
+// the client and server addresses each terminated by a space, the 4-byte
+// big-endian chunk size, then the chunk itself.
+func buildRow(dir Direction, chunk []byte) []byte {
+	row := []byte{byte(dir)}
+
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], 1_700_000_000_000_000_000)
+	row = append(row, ts[:]...)
+
+	row = append(row, "10.0.0.1:54321 "...)
+	row = append(row, "10.0.0.2:5432 "...)
+
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(chunk)))
+	row = append(row, size[:]...)
+
+	return append(row, chunk...)
+}
+
+func TestReplayLogStreamUnsupportedFormatVersion(t *testing.T) {
+	stream := append([]byte{FormatVersion + 1}, buildRow(DirectionClientToServer, nil)...)
+	rls := NewReplayLogStream(bytes.NewReader(stream))
+
+	if _, err := rls.Next(); !errors.Is(err, ErrUnsupportedFormatVersion) {
+		t.Fatalf("Next() error = %v, want %v", err, ErrUnsupportedFormatVersion)
+	}
+}
+
+func TestReplayLogStreamNextMessage(t *testing.T) {
+	parseComplete := (&pgproto3.ParseComplete{}).Encode(nil)
+	bind := (&pgproto3.Bind{}).Encode(nil)
+
+	stream := []byte{FormatVersion}
+	stream = append(stream, buildRow(DirectionServerToClient, parseComplete)...)
+	stream = append(stream, buildRow(DirectionClientToServer, bind)...)
+
+	rls := NewReplayLogStream(bytes.NewReader(stream))
+
+	tp, msg, err := rls.NextMessage()
+	if err != nil {
+		t.Fatalf("NextMessage() error = %v", err)
+	}
+	if tp.Direction != DirectionServerToClient {
+		t.Errorf("Direction = %v, want %v", tp.Direction, DirectionServerToClient)
+	}
+	if _, ok := msg.(*pgproto3.ParseComplete); !ok {
+		t.Errorf("NextMessage() = %#v, want *pgproto3.ParseComplete", msg)
+	}
+
+	tp, msg, err = rls.NextMessage()
+	if err != nil {
+		t.Fatalf("NextMessage() error = %v", err)
+	}
+	if tp.Direction != DirectionClientToServer {
+		t.Errorf("Direction = %v, want %v", tp.Direction, DirectionClientToServer)
+	}
+	if _, ok := msg.(*pgproto3.Bind); !ok {
+		t.Errorf("NextMessage() = %#v, want *pgproto3.Bind", msg)
+	}
+}
@@ -0,0 +1,95 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgproto3/v2"
+)
+
+// ErrParsingServerMessage is returned when a backend (server) message cannot
+// be parsed, e.g. an `AuthenticationRequest` with an unrecognized sub-type.
+var ErrParsingServerMessage = errors.New("postgres: error parsing server message")
+
+// Authentication request sub-message type codes, as sent in the first 4
+// bytes of an `AuthenticationRequest`'s payload.
+//
+// See: https://www.postgresql.org/docs/13/protocol-message-formats.html
+const (
+	authTypeOk                = 0
+	authTypeCleartextPassword = 3
+	authTypeMD5Password       = 5
+	authTypeGSS               = 7
+	authTypeGSSContinue       = 8
+	authTypeSASL              = 10
+	authTypeSASLContinue      = 11
+	authTypeSASLFinal         = 12
+)
+
+// parseAuthenticationRequest decodes the payload of a backend `'R'` message
+// (an `AuthenticationRequest`) into its concrete `pgproto3` type. Which
+// `'p'` message, if any, the frontend is now expected to respond with is
+// derived separately, from that concrete type, by
+// `ConnectionParser.ObserveBackendMessage`.
+func parseAuthenticationRequest(src []byte) (pgproto3.BackendMessage, error) {
+	if len(src) < 4 {
+		err := fmt.Errorf(
+			"%w; AuthenticationRequest must contain at least 4 bytes, has %d",
+			ErrParsingServerMessage, len(src),
+		)
+		return nil, err
+	}
+
+	authType := binary.BigEndian.Uint32(src[:4])
+	switch authType {
+	case authTypeOk:
+		am := &pgproto3.AuthenticationOk{}
+		return am, decodeAuth(am, src)
+	case authTypeCleartextPassword:
+		am := &pgproto3.AuthenticationCleartextPassword{}
+		return am, decodeAuth(am, src)
+	case authTypeMD5Password:
+		am := &pgproto3.AuthenticationMD5Password{}
+		return am, decodeAuth(am, src)
+	case authTypeGSS:
+		am := &pgproto3.AuthenticationGSS{}
+		return am, decodeAuth(am, src)
+	case authTypeGSSContinue:
+		am := &pgproto3.AuthenticationGSSContinue{}
+		return am, decodeAuth(am, src)
+	case authTypeSASL:
+		am := &pgproto3.AuthenticationSASL{}
+		return am, decodeAuth(am, src)
+	case authTypeSASLContinue:
+		am := &pgproto3.AuthenticationSASLContinue{}
+		return am, decodeAuth(am, src)
+	case authTypeSASLFinal:
+		am := &pgproto3.AuthenticationSASLFinal{}
+		return am, decodeAuth(am, src)
+	default:
+		err := fmt.Errorf(
+			"%w; unrecognized AuthenticationRequest type %d",
+			ErrParsingServerMessage, authType,
+		)
+		return nil, err
+	}
+}
+
+// decodeAuth decodes `src` into `am`, returning nil on success so it can be
+// used inline as a return value.
+func decodeAuth(am interface{ Decode([]byte) error }, src []byte) error {
+	return am.Decode(src)
+}
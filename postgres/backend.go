@@ -0,0 +1,214 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"fmt"
+
+	"github.com/jackc/pgproto3/v2"
+)
+
+// ParseBackendChunk parses a TCP packet sent from the backend (server) to
+// the frontend (client) as a PostgreSQL packet. Like `ParseChunk`, this
+// assumes a discrete TCP packet contains exactly one PostgreSQL packet.
+//
+// See:
+// - https://godoc.org/github.com/jackc/pgproto3
+// - https://www.postgresql.org/docs/13/protocol-message-formats.html
+func ParseBackendChunk(chunk []byte) (pgproto3.BackendMessage, error) {
+	if len(chunk) < 5 {
+		err := fmt.Errorf(
+			"%w; message must contain at least 5 bytes, has %d",
+			ErrParsingServerMessage, len(chunk),
+		)
+		return nil, err
+	}
+
+	messageType := chunk[0]
+	payload := chunk[5:]
+
+	switch messageType {
+	case 'R':
+		am, err := parseAuthenticationRequest(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		return am, nil
+	case 'K':
+		bkd := &pgproto3.BackendKeyData{}
+		err := bkd.Decode(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		return bkd, nil
+	case '2':
+		bc := &pgproto3.BindComplete{}
+		err := bc.Decode(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		return bc, nil
+	case '3':
+		cc := &pgproto3.CloseComplete{}
+		err := cc.Decode(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		return cc, nil
+	case 'C':
+		cc := &pgproto3.CommandComplete{}
+		err := cc.Decode(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		return cc, nil
+	case 'd':
+		cd := &pgproto3.CopyData{}
+		err := cd.Decode(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		return cd, nil
+	case 'c':
+		cd := &pgproto3.CopyDone{}
+		err := cd.Decode(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		return cd, nil
+	case 'G':
+		cir := &pgproto3.CopyInResponse{}
+		err := cir.Decode(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		return cir, nil
+	case 'H':
+		cor := &pgproto3.CopyOutResponse{}
+		err := cor.Decode(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		return cor, nil
+	case 'D':
+		dr := &pgproto3.DataRow{}
+		err := dr.Decode(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		return dr, nil
+	case 'I':
+		eqr := &pgproto3.EmptyQueryResponse{}
+		err := eqr.Decode(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		return eqr, nil
+	case 'E':
+		er := &pgproto3.ErrorResponse{}
+		err := er.Decode(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		return er, nil
+	case 'N':
+		nr := &pgproto3.NoticeResponse{}
+		err := nr.Decode(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		return nr, nil
+	case 'n':
+		nd := &pgproto3.NoData{}
+		err := nd.Decode(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		return nd, nil
+	case 'A':
+		nr := &pgproto3.NotificationResponse{}
+		err := nr.Decode(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		return nr, nil
+	case 't':
+		pd := &pgproto3.ParameterDescription{}
+		err := pd.Decode(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		return pd, nil
+	case 'S':
+		ps := &pgproto3.ParameterStatus{}
+		err := ps.Decode(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		return ps, nil
+	case '1':
+		pc := &pgproto3.ParseComplete{}
+		err := pc.Decode(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		return pc, nil
+	case 's':
+		ps := &pgproto3.PortalSuspended{}
+		err := ps.Decode(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		return ps, nil
+	case 'Z':
+		rfq := &pgproto3.ReadyForQuery{}
+		err := rfq.Decode(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		return rfq, nil
+	case 'T':
+		rd := &pgproto3.RowDescription{}
+		err := rd.Decode(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		return rd, nil
+	default:
+		err := fmt.Errorf(
+			"%w; unrecognized backend message type %q", ErrParsingServerMessage, messageType,
+		)
+		return nil, err
+	}
+}
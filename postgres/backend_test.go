@@ -0,0 +1,111 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/jackc/pgproto3/v2"
+)
+
+// backendEncoder is satisfied by every concrete `pgproto3.BackendMessage`
+// used below; `Encode` is how the test builds a wire-format chunk without
+// hand-rolling the framing `ParseBackendChunk` is meant to undo.
+type backendEncoder interface {
+	Encode(dst []byte) []byte
+}
+
+// TestParseBackendChunk round-trips one message per `ParseBackendChunk`
+// `case` through its real `Encode` method, to catch a mis-sliced payload
+// offset in any single case.
+func TestParseBackendChunk(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  interface {
+			backendEncoder
+			pgproto3.BackendMessage
+		}
+	}{
+		{name: "AuthenticationOk", msg: &pgproto3.AuthenticationOk{}},
+		{name: "BackendKeyData", msg: &pgproto3.BackendKeyData{ProcessID: 1234, SecretKey: 5678}},
+		{name: "BindComplete", msg: &pgproto3.BindComplete{}},
+		{name: "CloseComplete", msg: &pgproto3.CloseComplete{}},
+		{name: "CommandComplete", msg: &pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")}},
+		{name: "CopyData", msg: &pgproto3.CopyData{Data: []byte("row bytes")}},
+		{name: "CopyDone", msg: &pgproto3.CopyDone{}},
+		{name: "CopyInResponse", msg: &pgproto3.CopyInResponse{OverallFormat: 0, ColumnFormatCodes: []uint16{0, 1}}},
+		{name: "CopyOutResponse", msg: &pgproto3.CopyOutResponse{OverallFormat: 0, ColumnFormatCodes: []uint16{0}}},
+		{name: "DataRow", msg: &pgproto3.DataRow{Values: [][]byte{[]byte("a"), nil, []byte("c")}}},
+		{name: "EmptyQueryResponse", msg: &pgproto3.EmptyQueryResponse{}},
+		{name: "ErrorResponse", msg: &pgproto3.ErrorResponse{Severity: "ERROR", Code: "42601", Message: "syntax error"}},
+		{name: "NoticeResponse", msg: &pgproto3.NoticeResponse{Severity: "WARNING", Code: "01000", Message: "a notice"}},
+		{name: "NoData", msg: &pgproto3.NoData{}},
+		{name: "NotificationResponse", msg: &pgproto3.NotificationResponse{PID: 42, Channel: "chan", Payload: "payload"}},
+		{name: "ParameterDescription", msg: &pgproto3.ParameterDescription{ParameterOIDs: []uint32{23, 25}}},
+		{name: "ParameterStatus", msg: &pgproto3.ParameterStatus{Name: "client_encoding", Value: "UTF8"}},
+		{name: "ParseComplete", msg: &pgproto3.ParseComplete{}},
+		{name: "PortalSuspended", msg: &pgproto3.PortalSuspended{}},
+		{name: "ReadyForQuery", msg: &pgproto3.ReadyForQuery{TxStatus: 'I'}},
+		{
+			name: "RowDescription",
+			msg: &pgproto3.RowDescription{
+				Fields: []pgproto3.FieldDescription{
+					{Name: []byte("id"), DataTypeOID: 23, DataTypeSize: 4, Format: pgproto3.BinaryFormat},
+				},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			chunk := tc.msg.Encode(nil)
+
+			got, err := ParseBackendChunk(chunk)
+			if err != nil {
+				t.Fatalf("ParseBackendChunk() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.msg) {
+				t.Errorf("ParseBackendChunk() = %#v, want %#v", got, tc.msg)
+			}
+		})
+	}
+}
+
+// TestParseBackendChunkAuthenticationRequest confirms the 'R' case still
+// defers to parseAuthenticationRequest for its sub-type dispatch.
+func TestParseBackendChunkAuthenticationRequest(t *testing.T) {
+	msg := &pgproto3.AuthenticationCleartextPassword{}
+
+	got, err := ParseBackendChunk(msg.Encode(nil))
+	if err != nil {
+		t.Fatalf("ParseBackendChunk() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, msg) {
+		t.Errorf("ParseBackendChunk() = %#v, want %#v", got, msg)
+	}
+}
+
+func TestParseBackendChunkTooShort(t *testing.T) {
+	if _, err := ParseBackendChunk([]byte("Z\x00\x00")); !errors.Is(err, ErrParsingServerMessage) {
+		t.Fatalf("ParseBackendChunk() error = %v, want %v", err, ErrParsingServerMessage)
+	}
+}
+
+func TestParseBackendChunkUnrecognizedType(t *testing.T) {
+	chunk := append([]byte{'?'}, (&pgproto3.BindComplete{}).Encode(nil)[1:]...)
+	if _, err := ParseBackendChunk(chunk); !errors.Is(err, ErrParsingServerMessage) {
+		t.Fatalf("ParseBackendChunk() error = %v, want %v", err, ErrParsingServerMessage)
+	}
+}
@@ -0,0 +1,118 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"github.com/jackc/pgproto3/v2"
+)
+
+// authExpectation identifies which concrete `'p'` message a
+// `ConnectionParser` expects next from the frontend, based on the most
+// recently observed `AuthenticationRequest` sent by the backend. A single
+// chunk does not carry enough context on its own to disambiguate
+// `GSSResponse`, `PasswordMessage`, `SASLInitialResponse` and `SASLResponse`;
+// this state is what makes the disambiguation possible.
+type authExpectation int
+
+const (
+	// authExpectationNone indicates no `AuthenticationRequest` has been
+	// observed (or the handshake has completed), so a `'p'` message cannot
+	// be disambiguated and is decoded as a `Byte1pMessage`.
+	authExpectationNone authExpectation = iota
+	authExpectationCleartextPassword
+	authExpectationMD5Password
+	authExpectationGSSResponse
+	authExpectationSASLInitialResponse
+	authExpectationSASLResponse
+)
+
+// ConnectionParser is a stateful, per-socket parser that tracks the
+// PostgreSQL authentication handshake (StartupMessage -> AuthenticationRequest
+// -> subsequent `'p'` responses) so that a `'p'` frontend message can be
+// decoded into the concrete `pgproto3` type the backend is actually
+// expecting, rather than the ambiguous `Byte1pMessage` stand-in used by
+// `ParseChunk`.
+//
+// A `ConnectionParser` is not safe for concurrent use; callers should use
+// one instance per socket, fed in wire order from both directions.
+type ConnectionParser struct {
+	expectation authExpectation
+}
+
+// NewConnectionParser creates a `ConnectionParser` with no handshake state.
+func NewConnectionParser() *ConnectionParser {
+	return &ConnectionParser{expectation: authExpectationNone}
+}
+
+// ParseFrontendChunk parses a TCP packet sent from the frontend (client) to
+// the backend (server). It behaves exactly like `ParseChunk`, except that a
+// `'p'` message is decoded using the `AuthenticationRequest` most recently
+// observed via `ObserveBackendMessage`, rather than being collapsed into a
+// `Byte1pMessage`.
+func (cp *ConnectionParser) ParseFrontendChunk(chunk []byte) (pgproto3.FrontendMessage, error) {
+	if len(chunk) >= 5 && chunk[0] == 'p' {
+		return cp.decodePasswordVariant(chunk[5:])
+	}
+
+	return ParseChunk(chunk)
+}
+
+// decodePasswordVariant decodes the payload of a `'p'` message into the
+// concrete type implied by `cp.expectation`, falling back to the ambiguous
+// `Byte1pMessage` when no `AuthenticationRequest` has been observed.
+func (cp *ConnectionParser) decodePasswordVariant(src []byte) (pgproto3.FrontendMessage, error) {
+	var fm pgproto3.FrontendMessage
+	switch cp.expectation {
+	case authExpectationCleartextPassword, authExpectationMD5Password:
+		fm = &pgproto3.PasswordMessage{}
+	case authExpectationGSSResponse:
+		fm = &pgproto3.GSSResponse{}
+	case authExpectationSASLInitialResponse:
+		fm = &pgproto3.SASLInitialResponse{}
+	case authExpectationSASLResponse:
+		fm = &pgproto3.SASLResponse{}
+	default:
+		fm = &Byte1pMessage{}
+	}
+
+	type decoder interface {
+		Decode(src []byte) error
+	}
+	if err := fm.(decoder).Decode(src); err != nil {
+		return nil, err
+	}
+
+	return fm, nil
+}
+
+// ObserveBackendMessage updates the handshake state tracked by `cp` from an
+// already-decoded backend message, typically the one `ParseBackendChunk`
+// just returned for the same chunk; it does not re-parse the chunk itself.
+// Messages other than an `AuthenticationRequest` variant leave `cp`
+// unchanged.
+func (cp *ConnectionParser) ObserveBackendMessage(bm pgproto3.BackendMessage) {
+	switch bm.(type) {
+	case *pgproto3.AuthenticationCleartextPassword:
+		cp.expectation = authExpectationCleartextPassword
+	case *pgproto3.AuthenticationMD5Password:
+		cp.expectation = authExpectationMD5Password
+	case *pgproto3.AuthenticationGSS, *pgproto3.AuthenticationGSSContinue:
+		cp.expectation = authExpectationGSSResponse
+	case *pgproto3.AuthenticationSASL:
+		cp.expectation = authExpectationSASLInitialResponse
+	case *pgproto3.AuthenticationSASLContinue:
+		cp.expectation = authExpectationSASLResponse
+	case *pgproto3.AuthenticationOk, *pgproto3.AuthenticationSASLFinal:
+		cp.expectation = authExpectationNone
+	}
+}
@@ -0,0 +1,159 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/jackc/pgproto3/v2"
+)
+
+// buildPChunk frames `payload` as a `'p'` frontend chunk: the message type
+// byte, the 4-byte big-endian length (payload plus itself), then `payload`.
+func buildPChunk(payload []byte) []byte {
+	chunk := make([]byte, 5, 5+len(payload))
+	chunk[0] = 'p'
+	binary.BigEndian.PutUint32(chunk[1:5], uint32(4+len(payload)))
+	return append(chunk, payload...)
+}
+
+func TestConnectionParserParseFrontendChunk(t *testing.T) {
+	saslInitialPayload := func(mechanism string, data []byte) []byte {
+		payload := append([]byte(mechanism), 0)
+		length := make([]byte, 4)
+		binary.BigEndian.PutUint32(length, uint32(len(data)))
+		payload = append(payload, length...)
+		return append(payload, data...)
+	}
+
+	tests := []struct {
+		name    string
+		observe pgproto3.BackendMessage
+		payload []byte
+		want    pgproto3.FrontendMessage
+	}{
+		{
+			name:    "cleartext password expects PasswordMessage",
+			observe: &pgproto3.AuthenticationCleartextPassword{},
+			payload: append([]byte("secret"), 0),
+			want:    &pgproto3.PasswordMessage{Password: "secret"},
+		},
+		{
+			name:    "MD5 password expects PasswordMessage",
+			observe: &pgproto3.AuthenticationMD5Password{},
+			payload: append([]byte("md5hash"), 0),
+			want:    &pgproto3.PasswordMessage{Password: "md5hash"},
+		},
+		{
+			name:    "GSS expects GSSResponse",
+			observe: &pgproto3.AuthenticationGSS{},
+			payload: []byte{0x01, 0x02, 0x03},
+			want:    &pgproto3.GSSResponse{Data: []byte{0x01, 0x02, 0x03}},
+		},
+		{
+			name:    "GSSContinue also expects GSSResponse",
+			observe: &pgproto3.AuthenticationGSSContinue{Data: []byte{0xAA}},
+			payload: []byte{0x04, 0x05},
+			want:    &pgproto3.GSSResponse{Data: []byte{0x04, 0x05}},
+		},
+		{
+			name:    "SASL expects SASLInitialResponse",
+			observe: &pgproto3.AuthenticationSASL{AuthMechanisms: []string{"SCRAM-SHA-256"}},
+			payload: saslInitialPayload("SCRAM-SHA-256", []byte("client-first-message")),
+			want: &pgproto3.SASLInitialResponse{
+				AuthMechanism: "SCRAM-SHA-256",
+				Data:          []byte("client-first-message"),
+			},
+		},
+		{
+			name:    "SASLContinue expects SASLResponse",
+			observe: &pgproto3.AuthenticationSASLContinue{Data: []byte("server-first-message")},
+			payload: []byte("client-final-message"),
+			want:    &pgproto3.SASLResponse{Data: []byte("client-final-message")},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cp := NewConnectionParser()
+			cp.ObserveBackendMessage(tc.observe)
+
+			got, err := cp.ParseFrontendChunk(buildPChunk(tc.payload))
+			if err != nil {
+				t.Fatalf("ParseFrontendChunk() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("ParseFrontendChunk() = %#v, want %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestConnectionParserByte1pFallback(t *testing.T) {
+	cp := NewConnectionParser()
+
+	got, err := cp.ParseFrontendChunk(buildPChunk([]byte("anything")))
+	if err != nil {
+		t.Fatalf("ParseFrontendChunk() error = %v", err)
+	}
+	want := &Byte1pMessage{Data: "anything"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseFrontendChunk() with no AuthenticationRequest observed = %#v, want %#v", got, want)
+	}
+}
+
+func TestConnectionParserResetsOnHandshakeCompletion(t *testing.T) {
+	tests := []struct {
+		name   string
+		finish pgproto3.BackendMessage
+	}{
+		{name: "AuthenticationOk", finish: &pgproto3.AuthenticationOk{}},
+		{name: "AuthenticationSASLFinal", finish: &pgproto3.AuthenticationSASLFinal{Data: []byte("verifier")}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cp := NewConnectionParser()
+			cp.ObserveBackendMessage(&pgproto3.AuthenticationCleartextPassword{})
+			cp.ObserveBackendMessage(tc.finish)
+
+			got, err := cp.ParseFrontendChunk(buildPChunk([]byte("anything")))
+			if err != nil {
+				t.Fatalf("ParseFrontendChunk() error = %v", err)
+			}
+			want := &Byte1pMessage{Data: "anything"}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("ParseFrontendChunk() after %s = %#v, want %#v", tc.name, got, want)
+			}
+		})
+	}
+}
+
+func TestConnectionParserObserveBackendMessageIgnoresNonAuth(t *testing.T) {
+	payload := append([]byte("SCRAM-SHA-256"), 0, 0, 0, 0, 0)
+
+	cp := NewConnectionParser()
+	cp.ObserveBackendMessage(&pgproto3.AuthenticationSASL{AuthMechanisms: []string{"SCRAM-SHA-256"}})
+
+	cp.ObserveBackendMessage(&pgproto3.ReadyForQuery{})
+
+	got, err := cp.ParseFrontendChunk(buildPChunk(payload))
+	if err != nil {
+		t.Fatalf("ParseFrontendChunk() error = %v", err)
+	}
+	if _, ok := got.(*pgproto3.SASLInitialResponse); !ok {
+		t.Errorf("ParseFrontendChunk() = %#v, want *pgproto3.SASLInitialResponse (unaffected by a non-auth message)", got)
+	}
+}
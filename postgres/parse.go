@@ -15,6 +15,7 @@ package postgres
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 
 	"github.com/jackc/pgproto3/v2"
@@ -27,6 +28,14 @@ var (
 	gssEncReq               = bigEndianPackUint32(8, 80877104)
 )
 
+// ErrParsingClientMessage is returned when a frontend (client) message
+// cannot be parsed as a PostgreSQL protocol message.
+var ErrParsingClientMessage = errors.New("postgres: error parsing client message")
+
+// ErrNotImplemented is returned when a message type is recognized but this
+// package does not (yet) support parsing it.
+var ErrNotImplemented = errors.New("postgres: not implemented")
+
 // ParseChunk parses a TCP packet as a PostgreSQL packet. This assumes a
 // discrete TCP packet contains exactly one PostgreSQL packet, but this
 // assumption may be revisted at a later time.
@@ -0,0 +1,46 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"net"
+)
+
+// Dialer establishes a connection to an upstream server. It is the unit a
+// `Router` resolves a downstream connection to.
+type Dialer interface {
+	DialContext(ctx context.Context) (net.Conn, error)
+}
+
+// NOTE: Ensure that
+//       - `TCPDialer` satisfies `Dialer`
+var (
+	_ Dialer = (*TCPDialer)(nil)
+)
+
+// TCPDialer is a `Dialer` that connects to a fixed upstream TCP address.
+type TCPDialer struct {
+	// Name identifies this upstream, e.g. for use as a map key by a `Router`.
+	Name string
+	// Address is a `host:port` pair passed directly to `net.Dialer.DialContext`.
+	Address string
+	// Dialer is used to establish the connection. The zero value dials with
+	// no timeout.
+	Dialer net.Dialer
+}
+
+// DialContext connects to the configured upstream address.
+func (d *TCPDialer) DialContext(ctx context.Context) (net.Conn, error) {
+	return d.Dialer.DialContext(ctx, "tcp", d.Address)
+}
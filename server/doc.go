@@ -18,4 +18,21 @@
 // - The consumer of the channel with TCP packets can be customized to write
 //   to disk, send packets over the network, etc.
 // - Minimally invasive metrics and tracing can be added as needed
+//
+// Before a downstream connection is wrapped and proxied, a `Router` may peek
+// its opening bytes (a TLS ClientHello or a PostgreSQL StartupMessage,
+// buffered by a `peekConn` so nothing is consumed) to decide which upstream
+// `Dialer` it should be proxied to, e.g. `SNIRouter` or
+// `PostgresStartupRouter`. `WrapProxyProtocol` is one such downstream wrap:
+// it strips a leading PROXY protocol v1/v2 header and reports the real
+// originating address via `RemoteAddr`, so a load balancer sitting in front
+// of this proxy doesn't clobber the address captured for a connection.
+//
+// That capture loop itself (the one building `parse.TCPPacket` rows from a
+// proxied connection and sending them to the consumer channel) does not
+// exist in this package yet; once it does, it must read `RemoteAddr` off of
+// the (possibly `WrapProxyProtocol`-wrapped) downstream `net.Conn` into
+// `TCPPacket.ClientAddr`, rather than the immediate peer address, or a
+// load balancer in front of this proxy will clobber the address recorded
+// for every connection.
 package server
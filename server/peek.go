@@ -0,0 +1,59 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"io"
+	"net"
+)
+
+// peekConn wraps a `net.Conn`, allowing bytes to be peeked from the start of
+// the stream without consuming them. Peeked bytes are buffered and replayed
+// to the first subsequent `Read` call(s), so a `Router` can inspect the
+// opening bytes of a connection (a TLS ClientHello, a PostgreSQL
+// StartupMessage) while leaving the full handshake intact for whatever
+// wraps or proxies the connection next.
+type peekConn struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+// newPeekConn wraps `c` in a `peekConn`.
+func newPeekConn(c net.Conn) *peekConn {
+	return &peekConn{Conn: c}
+}
+
+// peek reads and returns exactly `n` bytes from the start of the stream,
+// buffering them so later `Read` calls still observe them.
+func (pc *peekConn) peek(n int) ([]byte, error) {
+	if need := n - pc.buf.Len(); need > 0 {
+		tail := make([]byte, need)
+		if _, err := io.ReadFull(pc.Conn, tail); err != nil {
+			return nil, err
+		}
+		pc.buf.Write(tail)
+	}
+
+	return pc.buf.Bytes()[:n], nil
+}
+
+// Read first drains any buffered, previously-peeked bytes before falling
+// through to the wrapped `net.Conn`.
+func (pc *peekConn) Read(p []byte) (int, error) {
+	if pc.buf.Len() > 0 {
+		return pc.buf.Read(p)
+	}
+
+	return pc.Conn.Read(p)
+}
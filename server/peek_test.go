@@ -0,0 +1,116 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/jackc/pgproto3/v2"
+)
+
+// newTestPipeConn wraps one end of a `net.Pipe` in a `peekConn`, writing
+// `data` from the other end in the background and returning that other end
+// too; unlike `readOnlyConn`, a pipe supports writes, which
+// `DeclineSSLRequest` needs, and a test needs the client end to observe them.
+func newTestPipeConn(data []byte) (*peekConn, net.Conn) {
+	client, server := net.Pipe()
+	go func() {
+		client.Write(data)
+	}()
+	return newPeekConn(server), client
+}
+
+func TestPeekSSLRequestAndDeclineSSLRequest(t *testing.T) {
+	startup := (&pgproto3.StartupMessage{
+		ProtocolVersion: pgproto3.ProtocolVersionNumber,
+		Parameters:      map[string]string{"user": "alice"},
+	}).Encode(nil)
+
+	t.Run("reports an SSLRequest prelude and leaves it for DeclineSSLRequest", func(t *testing.T) {
+		sslRequest := (&pgproto3.SSLRequest{}).Encode(nil)
+		pc, client := newTestPipeConn(append(append([]byte{}, sslRequest...), startup...))
+
+		isSSL, err := PeekSSLRequest(pc)
+		if err != nil {
+			t.Fatalf("PeekSSLRequest() error = %v", err)
+		}
+		if !isSSL {
+			t.Fatal("PeekSSLRequest() = false, want true")
+		}
+
+		// Peeking must not have consumed the prelude; it's still there for
+		// DeclineSSLRequest to consume, and the StartupMessage bytes behind
+		// it must be untouched.
+		declineDone := make(chan error, 1)
+		go func() {
+			declineDone <- DeclineSSLRequest(pc)
+		}()
+
+		decline := make([]byte, 1)
+		if _, err := client.Read(decline); err != nil {
+			t.Fatalf("reading the decline byte written by DeclineSSLRequest: %v", err)
+		}
+		if decline[0] != 'N' {
+			t.Fatalf("decline byte = %q, want 'N'", decline[0])
+		}
+		if err := <-declineDone; err != nil {
+			t.Fatalf("DeclineSSLRequest() error = %v", err)
+		}
+
+		peeked, err := PeekStartupMessage(pc)
+		if err != nil {
+			t.Fatalf("PeekStartupMessage() error = %v", err)
+		}
+		if !bytes.Equal(peeked, startup) {
+			t.Errorf("PeekStartupMessage() = % x, want % x", peeked, startup)
+		}
+	})
+
+	t.Run("reports no SSLRequest prelude for a bare StartupMessage", func(t *testing.T) {
+		pc, _ := newTestPipeConn(startup)
+
+		isSSL, err := PeekSSLRequest(pc)
+		if err != nil {
+			t.Fatalf("PeekSSLRequest() error = %v", err)
+		}
+		if isSSL {
+			t.Fatal("PeekSSLRequest() = true, want false")
+		}
+
+		// Peeking the prelude candidate must not have consumed any of the
+		// StartupMessage bytes behind it.
+		peeked, err := PeekStartupMessage(pc)
+		if err != nil {
+			t.Fatalf("PeekStartupMessage() error = %v", err)
+		}
+		if !bytes.Equal(peeked, startup) {
+			t.Errorf("PeekStartupMessage() = % x, want % x", peeked, startup)
+		}
+	})
+}
+
+func TestPeekStartupMessageRejectsOversizedLength(t *testing.T) {
+	// An attacker-chosen length far larger than any real StartupMessage;
+	// PeekStartupMessage must reject it before peeking that many bytes.
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, 0xFFFFFFF0)
+	pc, _ := newTestPipeConn(header)
+
+	if _, err := PeekStartupMessage(pc); !errors.Is(err, ErrNoRoute) {
+		t.Fatalf("PeekStartupMessage() error = %v, want %v", err, ErrNoRoute)
+	}
+}
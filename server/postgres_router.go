@@ -0,0 +1,164 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgproto3/v2"
+
+	"github.com/dhermes/tcp-replay-reverse-proxy/postgres"
+)
+
+// NOTE: Ensure that
+//   - `PostgresStartupRouter` satisfies `Router`
+var (
+	_ Router = (*PostgresStartupRouter)(nil)
+)
+
+// postgresHandshakeDeadline bounds how long `PeekSSLRequest`/
+// `PeekStartupMessage` will wait for their prelude before giving up,
+// mirroring `proxyProtocolHeaderDeadline`.
+const postgresHandshakeDeadline = 5 * time.Second
+
+// maxStartupMessageLen caps the length `PeekStartupMessage` will trust from
+// an untrusted client before peeking it, so a bogus length (e.g.
+// `0xFFFFFFF0`) fails with `ErrNoRoute` instead of driving a multi-gigabyte
+// allocation, mirroring `maxProxyProtocolV1Header`/`maxPcapNgBlockLen`. Real
+// PostgreSQL servers reject startup packets over this same 10000-byte
+// limit, for the same reason.
+const maxStartupMessageLen = 10000
+
+// PostgresStartupRouter routes a PostgreSQL connection to an upstream
+// `Dialer` using the `database` (falling back to `user`) parameter of the
+// client's `StartupMessage`.
+type PostgresStartupRouter struct {
+	// Upstreams maps a `database` or `user` startup parameter to the
+	// `Dialer` it should be proxied to.
+	Upstreams map[string]Dialer
+	// Default is used when neither the `database` nor `user` parameter has
+	// an entry in Upstreams. A nil Default causes Route to return
+	// ErrNoRoute instead.
+	Default Dialer
+}
+
+// Route parses `peek` as a PostgreSQL `StartupMessage` (see
+// `PeekSSLRequest`/`DeclineSSLRequest` for handling the
+// `SSLRequest`/`GSSEncRequest` prelude, which must be resolved before
+// `peek` is captured) and resolves its `database`/`user` parameter to an
+// upstream `Dialer`.
+func (r *PostgresStartupRouter) Route(_ context.Context, peek []byte) (Dialer, error) {
+	fm, err := postgres.ParseChunk(peek)
+	if err != nil {
+		return nil, err
+	}
+
+	sm, ok := fm.(*pgproto3.StartupMessage)
+	if !ok {
+		return nil, fmt.Errorf("%w; expected a StartupMessage, got %T", ErrNoRoute, fm)
+	}
+
+	if database, ok := sm.Parameters["database"]; ok {
+		if d, ok := r.Upstreams[database]; ok {
+			return d, nil
+		}
+	}
+
+	if user, ok := sm.Parameters["user"]; ok {
+		if d, ok := r.Upstreams[user]; ok {
+			return d, nil
+		}
+	}
+
+	if r.Default != nil {
+		return r.Default, nil
+	}
+
+	return nil, fmt.Errorf(
+		"%w; no upstream for database=%q user=%q",
+		ErrNoRoute, sm.Parameters["database"], sm.Parameters["user"],
+	)
+}
+
+// PeekSSLRequest peeks the next 8 bytes off of `pc` and reports whether they
+// are an `SSLRequest`/`GSSEncRequest` prelude, without consuming anything or
+// writing a response. A caller must resolve this (accept it with a
+// TLS-terminating wrap, or decline it with `DeclineSSLRequest`) before
+// `PeekStartupMessage` is called, since declining unconditionally here would
+// make it impossible for a TLS-terminating wrap to ever run afterwards.
+func PeekSSLRequest(pc *peekConn) (bool, error) {
+	if err := pc.SetReadDeadline(time.Now().Add(postgresHandshakeDeadline)); err != nil {
+		return false, err
+	}
+	defer pc.SetReadDeadline(time.Time{})
+
+	header, err := pc.peek(8)
+	if err != nil {
+		return false, err
+	}
+
+	return isSSLOrGSSPrelude(header), nil
+}
+
+// DeclineSSLRequest writes the single `'N'` byte that declines an
+// `SSLRequest`/`GSSEncRequest` prelude and consumes it, so the client's
+// subsequent `StartupMessage` becomes the start of the stream.
+func DeclineSSLRequest(pc *peekConn) error {
+	if _, err := pc.Conn.Write([]byte{'N'}); err != nil {
+		return err
+	}
+	pc.buf.Next(8)
+	return nil
+}
+
+// PeekStartupMessage peeks the client's `StartupMessage` off of `pc` without
+// consuming it, so a `PostgresStartupRouter` can inspect it before the
+// connection is wrapped or proxied further. Any `SSLRequest`/`GSSEncRequest`
+// prelude must already be resolved (see `PeekSSLRequest`/`DeclineSSLRequest`)
+// before this is called; `PostgresStartupRouter` itself has no opinion on
+// TLS termination. The declared length is rejected with `ErrNoRoute` if it
+// exceeds `maxStartupMessageLen`, rather than peeked, since it comes
+// straight from the unauthenticated client.
+func PeekStartupMessage(pc *peekConn) ([]byte, error) {
+	if err := pc.SetReadDeadline(time.Now().Add(postgresHandshakeDeadline)); err != nil {
+		return nil, err
+	}
+	defer pc.SetReadDeadline(time.Time{})
+
+	header, err := pc.peek(4)
+	if err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[:4])
+	if length > maxStartupMessageLen {
+		return nil, fmt.Errorf(
+			"%w; StartupMessage length %d exceeds %d-byte maximum",
+			ErrNoRoute, length, maxStartupMessageLen,
+		)
+	}
+
+	return pc.peek(int(length))
+}
+
+func isSSLOrGSSPrelude(header []byte) bool {
+	if len(header) < 8 {
+		return false
+	}
+
+	code := binary.BigEndian.Uint32(header[4:8])
+	return code == 80877103 || code == 80877104
+}
@@ -0,0 +1,95 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgproto3/v2"
+)
+
+func startupMessagePeek(t *testing.T, params map[string]string) []byte {
+	t.Helper()
+
+	sm := &pgproto3.StartupMessage{ProtocolVersion: pgproto3.ProtocolVersionNumber, Parameters: params}
+	return sm.Encode(nil)
+}
+
+func TestPostgresStartupRouterRoute(t *testing.T) {
+	byDatabase := &stubDialer{name: "by-database"}
+	byUser := &stubDialer{name: "by-user"}
+	fallback := &stubDialer{name: "fallback"}
+
+	router := &PostgresStartupRouter{
+		Upstreams: map[string]Dialer{
+			"payments": byDatabase,
+			"alice":    byUser,
+		},
+		Default: fallback,
+	}
+
+	t.Run("routes by database", func(t *testing.T) {
+		peek := startupMessagePeek(t, map[string]string{"database": "payments", "user": "bob"})
+
+		d, err := router.Route(context.Background(), peek)
+		if err != nil {
+			t.Fatalf("Route() error = %v", err)
+		}
+		if d != byDatabase {
+			t.Errorf("Route() = %v, want %v", d, byDatabase)
+		}
+	})
+
+	t.Run("falls back to user when database has no entry", func(t *testing.T) {
+		peek := startupMessagePeek(t, map[string]string{"database": "unrouted", "user": "alice"})
+
+		d, err := router.Route(context.Background(), peek)
+		if err != nil {
+			t.Fatalf("Route() error = %v", err)
+		}
+		if d != byUser {
+			t.Errorf("Route() = %v, want %v", d, byUser)
+		}
+	})
+
+	t.Run("falls back to Default when neither matches", func(t *testing.T) {
+		peek := startupMessagePeek(t, map[string]string{"database": "unrouted", "user": "mallory"})
+
+		d, err := router.Route(context.Background(), peek)
+		if err != nil {
+			t.Fatalf("Route() error = %v", err)
+		}
+		if d != fallback {
+			t.Errorf("Route() = %v, want %v", d, fallback)
+		}
+	})
+
+	t.Run("no Default and no match returns ErrNoRoute", func(t *testing.T) {
+		router := &PostgresStartupRouter{Upstreams: map[string]Dialer{"payments": byDatabase}}
+		peek := startupMessagePeek(t, map[string]string{"database": "unrouted", "user": "mallory"})
+
+		if _, err := router.Route(context.Background(), peek); !errors.Is(err, ErrNoRoute) {
+			t.Fatalf("Route() error = %v, want %v", err, ErrNoRoute)
+		}
+	})
+
+	t.Run("non-StartupMessage returns ErrNoRoute", func(t *testing.T) {
+		peek := (&pgproto3.SSLRequest{}).Encode(nil)
+
+		if _, err := router.Route(context.Background(), peek); !errors.Is(err, ErrNoRoute) {
+			t.Fatalf("Route() error = %v, want %v", err, ErrNoRoute)
+		}
+	})
+}
@@ -0,0 +1,238 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrProxyProtocol is returned when a PROXY protocol header cannot be
+// parsed.
+var ErrProxyProtocol = errors.New("server: malformed PROXY protocol header")
+
+// proxyProtocolV2Signature is the fixed 12-byte signature that opens every
+// PROXY protocol v2 header.
+var proxyProtocolV2Signature = []byte{
+	0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A,
+}
+
+// proxyProtocolHeaderDeadline bounds how long `WrapProxyProtocol` will wait
+// for a complete header before giving up.
+const proxyProtocolHeaderDeadline = 5 * time.Second
+
+// maxProxyProtocolV1Header is the largest a v1 header is allowed to be, per
+// the spec (`PROXY UNKNOWN\r\n` plus the longest possible IPv6 address
+// pair).
+const maxProxyProtocolV1Header = 107
+
+// NOTE: Ensure that
+//       - `proxyProtocolConn` satisfies `net.Conn`
+var (
+	_ net.Conn = (*proxyProtocolConn)(nil)
+)
+
+// WrapProxyProtocol reads a PROXY protocol v1 or v2 header off of `conn`,
+// returning a `net.Conn` whose `RemoteAddr`/`LocalAddr` report the proxied
+// source/destination addresses rather than the immediate peer, e.g. an L4
+// load balancer terminating in front of this proxy. No bytes past the
+// header are consumed, so whatever wraps or parses the connection next
+// sees the first real application byte intact.
+func WrapProxyProtocol(conn net.Conn) (net.Conn, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(proxyProtocolHeaderDeadline)); err != nil {
+		return nil, err
+	}
+	defer conn.SetReadDeadline(time.Time{})
+
+	pc := newPeekConn(conn)
+
+	sig, err := pc.peek(len(proxyProtocolV2Signature))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProxyProtocol, err)
+	}
+
+	var src, dst net.Addr
+	if bytes.Equal(sig, proxyProtocolV2Signature) {
+		src, dst, err = parseProxyProtocolV2(pc)
+	} else {
+		src, dst, err = parseProxyProtocolV1(pc)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &proxyProtocolConn{peekConn: pc, srcAddr: src, dstAddr: dst}, nil
+}
+
+// parseProxyProtocolV1 parses the human-readable v1 header, e.g.
+// `PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n`.
+func parseProxyProtocolV1(pc *peekConn) (net.Addr, net.Addr, error) {
+	var line []byte
+	for n := 2; n <= maxProxyProtocolV1Header; n++ {
+		buf, err := pc.peek(n)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrProxyProtocol, err)
+		}
+
+		if buf[n-2] == '\r' && buf[n-1] == '\n' {
+			line = buf[:n-2]
+			pc.buf.Next(n)
+			break
+		}
+	}
+
+	if line == nil {
+		return nil, nil, fmt.Errorf(
+			"%w; v1 header exceeds %d bytes", ErrProxyProtocol, maxProxyProtocolV1Header,
+		)
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, nil, fmt.Errorf("%w; v1 header must start with %q", ErrProxyProtocol, "PROXY")
+	}
+
+	if fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+
+	if len(fields) != 6 {
+		return nil, nil, fmt.Errorf(
+			"%w; v1 header has %d fields, expected 6", ErrProxyProtocol, len(fields),
+		)
+	}
+
+	srcIP := net.ParseIP(fields[2])
+	dstIP := net.ParseIP(fields[3])
+	if srcIP == nil || dstIP == nil {
+		return nil, nil, fmt.Errorf("%w; invalid v1 address in %q", ErrProxyProtocol, line)
+	}
+
+	srcPort, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w; invalid v1 source port: %v", ErrProxyProtocol, err)
+	}
+
+	dstPort, err := strconv.ParseUint(fields[5], 10, 16)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w; invalid v1 destination port: %v", ErrProxyProtocol, err)
+	}
+
+	return &net.TCPAddr{IP: srcIP, Port: int(srcPort)}, &net.TCPAddr{IP: dstIP, Port: int(dstPort)}, nil
+}
+
+// parseProxyProtocolV2 parses the binary v2 header: the 12-byte signature
+// (already peeked by the caller), one version/command byte, one
+// address-family/protocol byte, a 2-byte big-endian address block length,
+// then the address block itself.
+func parseProxyProtocolV2(pc *peekConn) (net.Addr, net.Addr, error) {
+	header, err := pc.peek(16)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrProxyProtocol, err)
+	}
+
+	verCmd := header[12]
+	if verCmd>>4 != 0x2 {
+		return nil, nil, fmt.Errorf("%w; unsupported v2 version %d", ErrProxyProtocol, verCmd>>4)
+	}
+	cmd := verCmd & 0x0F
+
+	family := header[13] >> 4
+	length := binary.BigEndian.Uint16(header[14:16])
+
+	full, err := pc.peek(16 + int(length))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrProxyProtocol, err)
+	}
+	pc.buf.Next(len(full))
+
+	// LOCAL: health check or similar, from the proxy itself. No address to
+	// report; the immediate peer address remains correct.
+	if cmd == 0x0 {
+		return nil, nil, nil
+	}
+
+	if cmd != 0x1 {
+		return nil, nil, fmt.Errorf("%w; unsupported v2 command %d", ErrProxyProtocol, cmd)
+	}
+
+	addrs := full[16:]
+	switch family {
+	case 0x1: // AF_INET
+		if len(addrs) < 12 {
+			return nil, nil, fmt.Errorf("%w; truncated v2 AF_INET address block", ErrProxyProtocol)
+		}
+		src := &net.TCPAddr{IP: net.IP(addrs[0:4]), Port: int(binary.BigEndian.Uint16(addrs[8:10]))}
+		dst := &net.TCPAddr{IP: net.IP(addrs[4:8]), Port: int(binary.BigEndian.Uint16(addrs[10:12]))}
+		return src, dst, nil
+	case 0x2: // AF_INET6
+		if len(addrs) < 36 {
+			return nil, nil, fmt.Errorf("%w; truncated v2 AF_INET6 address block", ErrProxyProtocol)
+		}
+		src := &net.TCPAddr{IP: net.IP(addrs[0:16]), Port: int(binary.BigEndian.Uint16(addrs[32:34]))}
+		dst := &net.TCPAddr{IP: net.IP(addrs[16:32]), Port: int(binary.BigEndian.Uint16(addrs[34:36]))}
+		return src, dst, nil
+	case 0x3: // AF_UNIX
+		if len(addrs) < 216 {
+			return nil, nil, fmt.Errorf("%w; truncated v2 AF_UNIX address block", ErrProxyProtocol)
+		}
+		src := &net.UnixAddr{Net: "unix", Name: cString(addrs[0:108])}
+		dst := &net.UnixAddr{Net: "unix", Name: cString(addrs[108:216])}
+		return src, dst, nil
+	default: // AF_UNSPEC
+		return nil, nil, nil
+	}
+}
+
+// cString trims a NUL-padded fixed-width field down to its string content.
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// proxyProtocolConn overrides the address methods of a wrapped connection
+// with the source/destination addresses parsed from a PROXY protocol
+// header.
+type proxyProtocolConn struct {
+	*peekConn
+	srcAddr net.Addr
+	dstAddr net.Addr
+}
+
+// RemoteAddr returns the proxied source address, if one was present in the
+// header, falling back to the immediate peer's address otherwise.
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	if c.srcAddr != nil {
+		return c.srcAddr
+	}
+
+	return c.peekConn.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the proxied destination address, if one was present in
+// the header, falling back to the immediate local address otherwise.
+func (c *proxyProtocolConn) LocalAddr() net.Addr {
+	if c.dstAddr != nil {
+		return c.dstAddr
+	}
+
+	return c.peekConn.Conn.LocalAddr()
+}
@@ -0,0 +1,151 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+)
+
+// newTestPeekConn wraps `data` in a `peekConn` over the package's existing
+// `readOnlyConn` (see sni_router.go), the same no-op `net.Conn` used to feed
+// already-peeked bytes to `tls.Server` for SNI parsing.
+func newTestPeekConn(data []byte) *peekConn {
+	return newPeekConn(&readOnlyConn{r: bytes.NewReader(data)})
+}
+
+func TestParseProxyProtocolV1(t *testing.T) {
+	t.Run("UNKNOWN", func(t *testing.T) {
+		pc := newTestPeekConn([]byte("PROXY UNKNOWN\r\n"))
+		src, dst, err := parseProxyProtocolV1(pc)
+		if err != nil {
+			t.Fatalf("parseProxyProtocolV1() error = %v, want nil", err)
+		}
+		if src != nil || dst != nil {
+			t.Fatalf("parseProxyProtocolV1() = (%v, %v), want (nil, nil)", src, dst)
+		}
+	})
+
+	t.Run("TCP4", func(t *testing.T) {
+		pc := newTestPeekConn([]byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n"))
+		src, dst, err := parseProxyProtocolV1(pc)
+		if err != nil {
+			t.Fatalf("parseProxyProtocolV1() error = %v, want nil", err)
+		}
+
+		wantSrc := &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 56324}
+		wantDst := &net.TCPAddr{IP: net.ParseIP("192.168.0.11"), Port: 443}
+		if src.String() != wantSrc.String() || dst.String() != wantDst.String() {
+			t.Fatalf("parseProxyProtocolV1() = (%v, %v), want (%v, %v)", src, dst, wantSrc, wantDst)
+		}
+	})
+
+	t.Run("malformed, too few fields", func(t *testing.T) {
+		pc := newTestPeekConn([]byte("PROXY TCP4 192.168.0.1\r\n"))
+		if _, _, err := parseProxyProtocolV1(pc); !errors.Is(err, ErrProxyProtocol) {
+			t.Fatalf("parseProxyProtocolV1() error = %v, want %v", err, ErrProxyProtocol)
+		}
+	})
+
+	t.Run("truncated, no CRLF within the v1 header limit", func(t *testing.T) {
+		pc := newTestPeekConn([]byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324"))
+		if _, _, err := parseProxyProtocolV1(pc); !errors.Is(err, ErrProxyProtocol) {
+			t.Fatalf("parseProxyProtocolV1() error = %v, want %v", err, ErrProxyProtocol)
+		}
+	})
+}
+
+// buildV2Header assembles a binary PROXY protocol v2 header: the fixed
+// signature, a version/command byte, an address-family/protocol byte, the
+// address block's length, then the address block itself.
+func buildV2Header(cmd, family byte, addrs []byte) []byte {
+	header := append([]byte{}, proxyProtocolV2Signature...)
+	header = append(header, 0x20|cmd, family)
+
+	length := make([]byte, 2)
+	binary.BigEndian.PutUint16(length, uint16(len(addrs)))
+	header = append(header, length...)
+
+	return append(header, addrs...)
+}
+
+func TestParseProxyProtocolV2(t *testing.T) {
+	t.Run("AF_INET", func(t *testing.T) {
+		addrs := make([]byte, 12)
+		copy(addrs[0:4], net.ParseIP("10.0.0.1").To4())
+		copy(addrs[4:8], net.ParseIP("10.0.0.2").To4())
+		binary.BigEndian.PutUint16(addrs[8:10], 54321)
+		binary.BigEndian.PutUint16(addrs[10:12], 5432)
+
+		pc := newTestPeekConn(buildV2Header(0x1, 0x1<<4, addrs))
+		src, dst, err := parseProxyProtocolV2(pc)
+		if err != nil {
+			t.Fatalf("parseProxyProtocolV2() error = %v, want nil", err)
+		}
+
+		wantSrc := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 54321}
+		wantDst := &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 5432}
+		if src.String() != wantSrc.String() || dst.String() != wantDst.String() {
+			t.Fatalf("parseProxyProtocolV2() = (%v, %v), want (%v, %v)", src, dst, wantSrc, wantDst)
+		}
+	})
+
+	t.Run("AF_INET6", func(t *testing.T) {
+		addrs := make([]byte, 36)
+		copy(addrs[0:16], net.ParseIP("fd00::1").To16())
+		copy(addrs[16:32], net.ParseIP("fd00::2").To16())
+		binary.BigEndian.PutUint16(addrs[32:34], 11111)
+		binary.BigEndian.PutUint16(addrs[34:36], 5432)
+
+		pc := newTestPeekConn(buildV2Header(0x1, 0x2<<4, addrs))
+		src, dst, err := parseProxyProtocolV2(pc)
+		if err != nil {
+			t.Fatalf("parseProxyProtocolV2() error = %v, want nil", err)
+		}
+
+		wantSrc := &net.TCPAddr{IP: net.ParseIP("fd00::1"), Port: 11111}
+		wantDst := &net.TCPAddr{IP: net.ParseIP("fd00::2"), Port: 5432}
+		if src.String() != wantSrc.String() || dst.String() != wantDst.String() {
+			t.Fatalf("parseProxyProtocolV2() = (%v, %v), want (%v, %v)", src, dst, wantSrc, wantDst)
+		}
+	})
+
+	t.Run("LOCAL command carries no address", func(t *testing.T) {
+		pc := newTestPeekConn(buildV2Header(0x0, 0x1<<4, make([]byte, 12)))
+		src, dst, err := parseProxyProtocolV2(pc)
+		if err != nil {
+			t.Fatalf("parseProxyProtocolV2() error = %v, want nil", err)
+		}
+		if src != nil || dst != nil {
+			t.Fatalf("parseProxyProtocolV2() = (%v, %v), want (nil, nil)", src, dst)
+		}
+	})
+
+	t.Run("truncated AF_INET address block does not panic", func(t *testing.T) {
+		pc := newTestPeekConn(buildV2Header(0x1, 0x1<<4, make([]byte, 4)))
+		if _, _, err := parseProxyProtocolV2(pc); !errors.Is(err, ErrProxyProtocol) {
+			t.Fatalf("parseProxyProtocolV2() error = %v, want %v", err, ErrProxyProtocol)
+		}
+	})
+
+	t.Run("header truncated before the declared address block arrives", func(t *testing.T) {
+		header := buildV2Header(0x1, 0x1<<4, make([]byte, 12))
+		pc := newTestPeekConn(header[:len(header)-6])
+		if _, _, err := parseProxyProtocolV2(pc); !errors.Is(err, ErrProxyProtocol) {
+			t.Fatalf("parseProxyProtocolV2() error = %v, want %v", err, ErrProxyProtocol)
+		}
+	})
+}
@@ -0,0 +1,23 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import "context"
+
+// Router resolves a downstream connection to the upstream `Dialer` it should
+// be proxied to, based on bytes peeked from the start of the connection
+// (e.g. a TLS ClientHello or a PostgreSQL StartupMessage). `peek` is never
+// consumed from the downstream connection; implementations only inspect it.
+type Router interface {
+	Route(ctx context.Context, peek []byte) (Dialer, error)
+}
@@ -0,0 +1,154 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// TLS record/handshake framing `PeekClientHelloLength` needs: a record
+// header is 1-byte content type + 2-byte version + 2-byte length, and a
+// handshake message header is 1-byte type + 3-byte length.
+const (
+	tlsRecordHeaderLen          = 5
+	tlsHandshakeHeaderLen       = 4
+	tlsContentTypeHandshake     = 22
+	tlsHandshakeTypeClientHello = 1
+)
+
+// NOTE: Ensure that
+//   - `SNIRouter` satisfies `Router`
+var (
+	_ Router = (*SNIRouter)(nil)
+)
+
+// SNIRouter routes a TLS connection to an upstream `Dialer` by the hostname
+// in the ClientHello's SNI extension, mirroring the approach taken by
+// `inetaf/tcpproxy`.
+type SNIRouter struct {
+	// Upstreams maps a ClientHello hostname to the `Dialer` it should be
+	// proxied to.
+	Upstreams map[string]Dialer
+	// Default is used when the ClientHello's hostname has no entry in
+	// Upstreams. A nil Default causes Route to return ErrNoRoute instead.
+	Default Dialer
+}
+
+// Route inspects `peek` as a TLS ClientHello and resolves its SNI hostname
+// to an upstream `Dialer`.
+func (r *SNIRouter) Route(_ context.Context, peek []byte) (Dialer, error) {
+	hostname, err := sniHostname(peek)
+	if err != nil {
+		return nil, err
+	}
+
+	if d, ok := r.Upstreams[hostname]; ok {
+		return d, nil
+	}
+
+	if r.Default != nil {
+		return r.Default, nil
+	}
+
+	return nil, fmt.Errorf("%w; no upstream for SNI hostname %q", ErrNoRoute, hostname)
+}
+
+// PeekClientHelloLength peeks enough of `pc` to compute the total byte
+// length of the TLS record carrying the ClientHello, so a caller knows how
+// many bytes to peek before calling `SNIRouter.Route`. It parses the TLS
+// record header's 2-byte length and the handshake message's 3-byte length,
+// the same way `PeekStartupMessage` reads the PostgreSQL length prefix,
+// checking that the handshake message fits within its record.
+func PeekClientHelloLength(pc *peekConn) (int, error) {
+	recordHeader, err := pc.peek(tlsRecordHeaderLen)
+	if err != nil {
+		return 0, err
+	}
+	if recordHeader[0] != tlsContentTypeHandshake {
+		return 0, fmt.Errorf(
+			"%w; expected a TLS handshake record, got content type %d",
+			ErrNoRoute, recordHeader[0],
+		)
+	}
+	recordLen := int(binary.BigEndian.Uint16(recordHeader[3:5]))
+
+	handshakeHeader, err := pc.peek(tlsRecordHeaderLen + tlsHandshakeHeaderLen)
+	if err != nil {
+		return 0, err
+	}
+	if handshakeHeader[5] != tlsHandshakeTypeClientHello {
+		return 0, fmt.Errorf(
+			"%w; expected a ClientHello, got handshake type %d",
+			ErrNoRoute, handshakeHeader[5],
+		)
+	}
+	handshakeLen := int(handshakeHeader[6])<<16 | int(handshakeHeader[7])<<8 | int(handshakeHeader[8])
+	if handshakeLen+tlsHandshakeHeaderLen > recordLen {
+		return 0, fmt.Errorf("%w; ClientHello handshake length exceeds its TLS record", ErrNoRoute)
+	}
+
+	return tlsRecordHeaderLen + recordLen, nil
+}
+
+// errSNIHostnameFound is a sentinel used to unwind `tls.Conn.Handshake` as
+// soon as the ClientHello has been parsed; a peeked ClientHello is never
+// followed by the rest of a real handshake, so `Handshake` is expected to
+// fail every time this is used.
+var errSNIHostnameFound = errors.New("server: sni hostname parsed")
+
+// sniHostname extracts the SNI hostname from a TLS ClientHello by handing
+// `peek` to the standard library's record parser via `tls.Server` and
+// capturing the `ClientHelloInfo` passed to `GetConfigForClient`.
+func sniHostname(peek []byte) (string, error) {
+	var hostname string
+	conn := tls.Server(&readOnlyConn{r: bytes.NewReader(peek)}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			hostname = hello.ServerName
+			return nil, errSNIHostnameFound
+		},
+	})
+	defer conn.Close()
+
+	err := conn.Handshake()
+	if errors.Is(err, errSNIHostnameFound) {
+		return hostname, nil
+	}
+
+	return "", fmt.Errorf("%w; failed to parse ClientHello: %v", ErrNoRoute, err)
+}
+
+// readOnlyConn adapts an `io.Reader` of already-peeked bytes into a
+// `net.Conn` suitable for `tls.Server`, which only needs to read the
+// ClientHello record; writes and all other `net.Conn` methods are no-ops.
+type readOnlyConn struct {
+	r interface {
+		Read(p []byte) (int, error)
+	}
+}
+
+func (c *readOnlyConn) Read(p []byte) (int, error)         { return c.r.Read(p) }
+func (c *readOnlyConn) Write(p []byte) (int, error)        { return 0, io.ErrClosedPipe }
+func (c *readOnlyConn) Close() error                       { return nil }
+func (c *readOnlyConn) LocalAddr() net.Addr                { return nil }
+func (c *readOnlyConn) RemoteAddr() net.Addr               { return nil }
+func (c *readOnlyConn) SetDeadline(t time.Time) error      { return nil }
+func (c *readOnlyConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *readOnlyConn) SetWriteDeadline(t time.Time) error { return nil }
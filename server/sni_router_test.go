@@ -0,0 +1,118 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"testing"
+)
+
+// stubDialer is a no-op `Dialer` used only to be compared by identity in
+// routing tests; it is never actually dialed.
+type stubDialer struct {
+	name string
+}
+
+func (d *stubDialer) DialContext(_ context.Context) (net.Conn, error) {
+	return nil, nil
+}
+
+// captureClientHello runs a real TLS handshake over a `net.Pipe` far enough
+// to capture the raw bytes of the client's ClientHello record, so routing
+// tests exercise `tls.Server`'s own record parser rather than a hand-rolled
+// fixture.
+func captureClientHello(t *testing.T, serverName string) []byte {
+	t.Helper()
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	go func() {
+		//nolint:errcheck // the handshake is expected to fail; only its ClientHello is wanted.
+		tls.Client(clientConn, &tls.Config{ServerName: serverName, InsecureSkipVerify: true}).Handshake()
+	}()
+
+	recordHeader := make([]byte, tlsRecordHeaderLen)
+	if _, err := readFullPipe(serverConn, recordHeader); err != nil {
+		t.Fatalf("reading ClientHello record header: %v", err)
+	}
+	recordLen := int(recordHeader[3])<<8 | int(recordHeader[4])
+
+	rest := make([]byte, recordLen)
+	if _, err := readFullPipe(serverConn, rest); err != nil {
+		t.Fatalf("reading ClientHello record body: %v", err)
+	}
+
+	return append(recordHeader, rest...)
+}
+
+// readFullPipe reads exactly `len(buf)` bytes from `r`, looping over
+// `net.Pipe`'s unbuffered reads the way `io.ReadFull` would.
+func readFullPipe(r net.Conn, buf []byte) (int, error) {
+	read := 0
+	for read < len(buf) {
+		n, err := r.Read(buf[read:])
+		read += n
+		if err != nil {
+			return read, err
+		}
+	}
+	return read, nil
+}
+
+func TestSNIRouterRoute(t *testing.T) {
+	example := &stubDialer{name: "example"}
+	fallback := &stubDialer{name: "fallback"}
+
+	t.Run("hit", func(t *testing.T) {
+		router := &SNIRouter{Upstreams: map[string]Dialer{"example.com": example}}
+		peek := captureClientHello(t, "example.com")
+
+		d, err := router.Route(context.Background(), peek)
+		if err != nil {
+			t.Fatalf("Route() error = %v", err)
+		}
+		if d != example {
+			t.Errorf("Route() = %v, want %v", d, example)
+		}
+	})
+
+	t.Run("miss falls back to Default", func(t *testing.T) {
+		router := &SNIRouter{
+			Upstreams: map[string]Dialer{"example.com": example},
+			Default:   fallback,
+		}
+		peek := captureClientHello(t, "unknown.example.net")
+
+		d, err := router.Route(context.Background(), peek)
+		if err != nil {
+			t.Fatalf("Route() error = %v", err)
+		}
+		if d != fallback {
+			t.Errorf("Route() = %v, want %v", d, fallback)
+		}
+	})
+
+	t.Run("miss with no Default returns ErrNoRoute", func(t *testing.T) {
+		router := &SNIRouter{Upstreams: map[string]Dialer{"example.com": example}}
+		peek := captureClientHello(t, "unknown.example.net")
+
+		if _, err := router.Route(context.Background(), peek); !errors.Is(err, ErrNoRoute) {
+			t.Fatalf("Route() error = %v, want %v", err, ErrNoRoute)
+		}
+	})
+}